@@ -0,0 +1,251 @@
+// Package ldap provides an LDAP or Active Directory authentication source
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+
+	"github.com/fclairamb/ftpserver/auth/render"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Default settings
+const (
+	DefaultUserFilter     = "(uid={{.User}})"
+	DefaultLoginAttribute = "uid"
+	DefaultGroupAttribute = "memberOf"
+	DefaultTimeout        = 10 * time.Second
+)
+
+// ErrInvalidCredentials is returned when the user can't be found or the password doesn't match
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrNoLogin is returned when the entry of the user doesn't have the login attribute
+var ErrNoLogin = errors.New("no login attribute for the user")
+
+// ErrNoGroup is returned when the user isn't a member of any of the configured groups
+var ErrNoGroup = errors.New("no access for the groups of the user")
+
+// ErrInvalidCA is returned when the CA bundle doesn't contain any certificate
+var ErrInvalidCA = errors.New("no certificate found in the CA bundle")
+
+// GetAccess authenticates a user against the LDAP server and returns the access of its first matching group, with
+// the LDAP attributes of the user
+func GetAccess(conf *confpar.LDAP, user, pass string) (*confpar.Access, error) {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if user == "" || pass == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := dial(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	entry, err := findUser(conn, conf, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, pass); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, fmt.Errorf("could not bind as %s: %w", entry.DN, err)
+	}
+
+	login, err := loginName(conf, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := matchGroup(conf, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The params are rendered once the access is resolved, with the attributes of the user
+	access := *group.Access
+	access.User = login
+	access.Pass = ""
+	access.Attributes = make(map[string]string, len(entry.Attributes))
+
+	for _, attr := range entry.Attributes {
+		if len(attr.Values) > 0 {
+			access.Attributes[attr.Name] = attr.Values[0]
+		}
+	}
+
+	return &access, nil
+}
+
+// dial connects to the LDAP server and binds with the service account
+func dial(conf *confpar.LDAP) (*goldap.Conn, error) {
+	timeout := conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	tlsConfig, err := tlsConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := goldap.DialURL(
+		conf.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		goldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", conf.URL, err)
+	}
+
+	conn.SetTimeout(timeout)
+
+	if conf.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			_ = conn.Close()
+
+			return nil, fmt.Errorf("could not start TLS: %w", err)
+		}
+	}
+
+	if conf.BindDN != "" {
+		err = conn.Bind(conf.BindDN, conf.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("could not bind with the service account: %w", err)
+	}
+
+	return conn, nil
+}
+
+// tlsConfig returns the TLS config used for ldaps:// and StartTLS
+func tlsConfig(conf *confpar.LDAP) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: conf.InsecureSkipVerify, //nolint:gosec // Explicitly configured
+	}
+
+	if u, err := url.Parse(conf.URL); err == nil {
+		tlsConf.ServerName = u.Hostname()
+	}
+
+	if conf.CA != "" {
+		caBytes, err := os.ReadFile(conf.CA)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA file: %s: %w", conf.CA, err)
+		}
+
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, conf.CA)
+		}
+	}
+
+	return tlsConf, nil
+}
+
+// findUser searches the entry of a user
+func findUser(conn *goldap.Conn, conf *confpar.LDAP, user string) (*goldap.Entry, error) {
+	userFilter := conf.UserFilter
+	if userFilter == "" {
+		userFilter = DefaultUserFilter
+	}
+
+	filter, err := render.String(userFilter, map[string]string{"User": goldap.EscapeFilter(user)})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Search(goldap.NewSearchRequest(
+		conf.BaseDN,
+		goldap.ScopeWholeSubtree,
+		goldap.NeverDerefAliases,
+		2, // Only one entry is expected
+		0,
+		false,
+		filter,
+		nil,
+		nil,
+	))
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("could not search for %s: %w", user, err)
+	}
+
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return result.Entries[0], nil
+}
+
+// loginName returns the canonical name of a user. The LDAP server might match the typed name regardless of its case or
+// of its spaces, it shouldn't be used to name the user directories or to count the sessions.
+func loginName(conf *confpar.LDAP, entry *goldap.Entry) (string, error) {
+	loginAttribute := conf.LoginAttribute
+	if loginAttribute == "" {
+		loginAttribute = DefaultLoginAttribute
+	}
+
+	login := entry.GetEqualFoldAttributeValue(loginAttribute)
+	if login == "" {
+		return "", fmt.Errorf("%w: %s has no %s", ErrNoLogin, entry.DN, loginAttribute)
+	}
+
+	return login, nil
+}
+
+// matchGroup returns the first group the user is a member of
+func matchGroup(conf *confpar.LDAP, entry *goldap.Entry) (*confpar.LDAPGroup, error) {
+	groupAttribute := conf.GroupAttribute
+	if groupAttribute == "" {
+		groupAttribute = DefaultGroupAttribute
+	}
+
+	memberOf := entry.GetEqualFoldAttributeValues(groupAttribute)
+
+	for _, group := range conf.Groups {
+		if group.Access == nil {
+			continue
+		}
+
+		if group.Group == "" || isMember(memberOf, group.Group) {
+			return group, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoGroup, entry.DN)
+}
+
+// isMember checks if a group DN is part of a list of DNs
+func isMember(memberOf []string, group string) bool {
+	groupDN, err := goldap.ParseDN(group)
+	if err != nil {
+		return false
+	}
+
+	for _, member := range memberOf {
+		if memberDN, err := goldap.ParseDN(member); err == nil && memberDN.EqualFold(groupDN) {
+			return true
+		}
+	}
+
+	return false
+}
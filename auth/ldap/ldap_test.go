@@ -0,0 +1,253 @@
+package ldap
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// testEntry is an entry of the LDAP stand-in
+type testEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testServer is a minimal LDAP server supporting simple binds and equality searches
+type testServer struct {
+	listener net.Listener
+	entries  []*testEntry
+}
+
+func newTestServer(t *testing.T, entries ...*testEntry) *testServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen: %v", err)
+	}
+
+	srv := &testServer{listener: listener, entries: entries}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go srv.serve()
+
+	return srv
+}
+
+func (s *testServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		msgID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			responses = append(responses, s.bind(op))
+		case goldap.ApplicationSearchRequest:
+			responses = s.search(op)
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+			envelope.AppendChild(response)
+
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	return packet
+}
+
+func (s *testServer) bind(op *ber.Packet) *ber.Packet {
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password == password {
+			return result(goldap.ApplicationBindResponse, goldap.LDAPResultSuccess)
+		}
+	}
+
+	return result(goldap.ApplicationBindResponse, goldap.LDAPResultInvalidCredentials)
+}
+
+func (s *testServer) search(op *ber.Packet) []*ber.Packet {
+	filter, err := goldap.DecompileFilter(op.Children[6])
+	if err != nil {
+		return []*ber.Packet{result(goldap.ApplicationSearchResultDone, goldap.LDAPResultProtocolError)}
+	}
+
+	var responses []*ber.Packet
+
+	for _, entry := range s.entries {
+		if !entry.matches(filter) {
+			continue
+		}
+
+		packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "")
+		packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+
+		for name, values := range entry.attributes {
+			attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+
+		packet.AppendChild(attributes)
+		responses = append(responses, packet)
+	}
+
+	return append(responses, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess))
+}
+
+// matches only supports the filters made of equality assertions
+func (e *testEntry) matches(filter string) bool {
+	for name, values := range e.attributes {
+		for _, value := range values {
+			// Like most attributes of the user entries, the values are matched regardless of their case
+			if strings.Contains(strings.ToLower(filter), strings.ToLower("("+name+"="+goldap.EscapeFilter(value)+")")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func TestGetAccess(t *testing.T) {
+	srv := newTestServer(t,
+		&testEntry{dn: "cn=admin,dc=example,dc=org", password: "admin"},
+		&testEntry{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			password: "alice-secret",
+			attributes: map[string][]string{
+				"uid":      {"alice"},
+				"memberOf": {"cn=ops,ou=groups,dc=example,dc=org"},
+			},
+		},
+		&testEntry{
+			dn:         "uid=bob,ou=people,dc=example,dc=org",
+			password:   "bob-secret",
+			attributes: map[string][]string{"uid": {"bob"}, "departmentNumber": {"42"}},
+		},
+	)
+
+	conf := &confpar.LDAP{
+		URL:          srv.url(),
+		BindDN:       "cn=admin,dc=example,dc=org",
+		BindPassword: "admin",
+		BaseDN:       "ou=people,dc=example,dc=org",
+		Groups: []*confpar.LDAPGroup{
+			{
+				Group: "CN=ops,OU=groups,DC=example,DC=org",
+				Access: &confpar.Access{
+					Fs:     "os",
+					Params: map[string]string{"basePath": "/data/{{.uid}}"},
+				},
+			},
+			{
+				Access: &confpar.Access{
+					Fs:       "os",
+					ReadOnly: true,
+					Params:   map[string]string{"basePath": "/public/{{.departmentNumber}}/{{.User}}"},
+				},
+			},
+		},
+	}
+
+	access, err := GetAccess(conf, "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("alice should be authenticated: %v", err)
+	}
+
+	if access.User != "alice" || access.ReadOnly || access.Attributes["uid"] != "alice" {
+		t.Fatalf("unexpected access for alice: %+v", access)
+	}
+
+	// The access is named after the entry, not after what the client typed
+	access, err = GetAccess(conf, "ALICE", "alice-secret")
+	if err != nil {
+		t.Fatalf("alice should be authenticated with any case: %v", err)
+	}
+
+	if access.User != "alice" {
+		t.Fatalf("expected the canonical name, got: %s", access.User)
+	}
+
+	access, err = GetAccess(conf, "bob", "bob-secret")
+	if err != nil {
+		t.Fatalf("bob should be authenticated: %v", err)
+	}
+
+	if !access.ReadOnly || access.Attributes["departmentNumber"] != "42" {
+		t.Fatalf("unexpected access for bob: %+v", access)
+	}
+
+	// The group access must not be modified
+	if conf.Groups[1].Access.User != "" || conf.Groups[1].Access.Attributes != nil {
+		t.Fatalf("the group access was modified: %+v", conf.Groups[1].Access)
+	}
+
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"alice", ""}, {"nobody", "secret"}, {"*", "alice-secret"}} {
+		if _, err := GetAccess(conf, creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials for %s/%s, got: %v", creds[0], creds[1], err)
+		}
+	}
+
+	conf.Groups = conf.Groups[:1]
+
+	if _, err := GetAccess(conf, "bob", "bob-secret"); !errors.Is(err, ErrNoGroup) {
+		t.Fatalf("expected ErrNoGroup, got: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package render renders the accesses params with per-user variables
+package render
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// String renders a template like "/data/{{.User}}" with some variables. Missing variables are reported as errors.
+func String(text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse template %q: %w", text, err)
+	}
+
+	var sb strings.Builder
+	if err := tpl.Execute(&sb, vars); err != nil {
+		return "", fmt.Errorf("could not render template %q: %w", text, err)
+	}
+
+	return sb.String(), nil
+}
+
+// Params returns a copy of some params rendered with some variables
+func Params(params map[string]string, vars map[string]string) (map[string]string, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	rendered := make(map[string]string, len(params))
+
+	for key, value := range params {
+		var err error
+
+		if rendered[key], err = String(value, vars); err != nil {
+			return nil, fmt.Errorf("param %s: %w", key, err)
+		}
+	}
+
+	return rendered, nil
+}
+
+// Access returns a copy of an access whose params, and the ones of its mounts, are rendered with some variables
+func Access(src *confpar.Access, vars map[string]string) (*confpar.Access, error) {
+	access := *src
+
+	var err error
+
+	if access.Params, err = Params(src.Params, vars); err != nil {
+		return nil, err
+	}
+
+	access.Mounts = make([]*confpar.Mount, 0, len(src.Mounts))
+
+	for _, srcMount := range src.Mounts {
+		mount := *srcMount
+
+		if mount.Params, err = Params(srcMount.Params, vars); err != nil {
+			return nil, fmt.Errorf("mount %s: %w", mount.Path, err)
+		}
+
+		access.Mounts = append(access.Mounts, &mount)
+	}
+
+	if src.Mounts == nil {
+		access.Mounts = nil
+	}
+
+	return &access, nil
+}
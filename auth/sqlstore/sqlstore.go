@@ -0,0 +1,208 @@
+// Package sqlstore provides a SQL database authentication source
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"  // PostgreSQL driver
+	_ "modernc.org/sqlite" // SQLite driver
+
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Default settings
+const (
+	DefaultCacheTTL         = 10 * time.Second
+	DefaultNegativeCacheTTL = 5 * time.Second
+	DefaultTimeout          = 5 * time.Second
+)
+
+// Default queries, per driver
+var defaultQueries = map[string]string{
+	"sqlite":   "SELECT user, pass, fs, params, read_only, shared FROM users WHERE user = ?",
+	"postgres": `SELECT "user", pass, fs, params, read_only, shared FROM users WHERE "user" = $1`,
+}
+
+// ErrUnsupportedDriver is returned when the driver isn't supported
+var ErrUnsupportedDriver = errors.New("unsupported SQL driver, expected sqlite or postgres")
+
+// cacheEntry is a cached query result, access is nil for unknown users
+type cacheEntry struct {
+	access  *confpar.Access
+	expires time.Time
+}
+
+// Store authenticates the users against a database
+type Store struct {
+	db    *sql.DB
+	conf  *confpar.SQL
+	query string
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+	now   func() time.Time
+}
+
+// NewStore opens the connections pool of a store
+func NewStore(conf *confpar.SQL) (*Store, error) {
+	query, ok := defaultQueries[conf.Driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, conf.Driver)
+	}
+
+	if conf.Query != "" {
+		query = conf.Query
+	}
+
+	db, err := sql.Open(conf.Driver, conf.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.ConnMaxLifetime.Duration)
+
+	return &Store{
+		db:    db,
+		conf:  conf,
+		query: query,
+		cache: make(map[string]*cacheEntry),
+		now:   time.Now,
+	}, nil
+}
+
+// Config returns the config the store was created with
+func (s *Store) Config() *confpar.SQL {
+	return s.conf
+}
+
+// Close closes the connections pool
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// GetAccess returns the access of a user after checking its password
+func (s *Store) GetAccess(user, pass string) (*confpar.Access, error) {
+	access, err := s.getUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if access == nil {
+		return nil, config.ErrUnknownUser
+	}
+
+	ok, err := config.MatchPassword(access.Pass, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, config.ErrUnknownUser
+	}
+
+	// The cached access must not be shared
+	result := *access
+
+	return &result, nil
+}
+
+// getUser returns the access of a user from the cache or the database, nil if it doesn't exist
+func (s *Store) getUser(user string) (*confpar.Access, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	entry := s.cache[user]
+	s.mu.Unlock()
+
+	if entry != nil && entry.expires.After(now) {
+		return entry.access, nil
+	}
+
+	access, err := s.queryUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := ttlOrDefault(s.conf.CacheTTL.Duration, DefaultCacheTTL)
+	if access == nil {
+		ttl = ttlOrDefault(s.conf.NegativeCacheTTL.Duration, DefaultNegativeCacheTTL)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired entries are dropped as we go, to keep the cache small
+	for key, cached := range s.cache {
+		if !cached.expires.After(now) {
+			delete(s.cache, key)
+		}
+	}
+
+	if ttl > 0 {
+		s.cache[user] = &cacheEntry{access: access, expires: now.Add(ttl)}
+	}
+
+	return access, nil
+}
+
+// ttlOrDefault returns the default TTL for zero values, negative values disable the cache
+func ttlOrDefault(ttl, defaultTTL time.Duration) time.Duration {
+	if ttl == 0 {
+		return defaultTTL
+	}
+
+	return ttl
+}
+
+// queryUser fetches the access of a user from the database, nil if it doesn't exist
+func (s *Store) queryUser(user string) (*confpar.Access, error) {
+	timeout := s.conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		access   confpar.Access
+		params   sql.NullString
+		readOnly sql.NullBool
+		shared   sql.NullBool
+	)
+
+	err := s.db.QueryRowContext(ctx, s.query, user).Scan(
+		&access.User,
+		&access.Pass,
+		&access.Fs,
+		&params,
+		&readOnly,
+		&shared,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil //nolint:nilnil // Unknown user
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not query user %s: %w", user, err)
+	}
+
+	if params.Valid && params.String != "" {
+		if err := json.Unmarshal([]byte(params.String), &access.Params); err != nil {
+			return nil, fmt.Errorf("could not parse params of user %s: %w", user, err)
+		}
+	}
+
+	access.ReadOnly = readOnly.Bool
+	access.Shared = shared.Bool
+
+	return &access, nil
+}
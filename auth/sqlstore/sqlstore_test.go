@@ -0,0 +1,127 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func newTestStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "users.db")
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("couldn't open database: %v", err)
+	}
+
+	t.Cleanup(func() { _ = db.Close() })
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("couldn't hash password: %v", err)
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE users (user TEXT PRIMARY KEY, pass TEXT, fs TEXT, params TEXT, read_only BOOLEAN, shared BOOLEAN)",
+		"INSERT INTO users VALUES ('alice', '" + string(hash) + "', 'os', '{\"basePath\": \"/tmp/alice\"}', 1, 0)",
+		"INSERT INTO users VALUES ('bob', 'plain', 'os', NULL, NULL, NULL)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("couldn't prepare database: %v", err)
+		}
+	}
+
+	store, err := NewStore(&confpar.SQL{Driver: "sqlite", DSN: dsn})
+	if err != nil {
+		t.Fatalf("couldn't create store: %v", err)
+	}
+
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store, db
+}
+
+func TestGetAccess(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	access, err := store.GetAccess("alice", "secret")
+	if err != nil {
+		t.Fatalf("couldn't authenticate: %v", err)
+	}
+
+	if access.User != "alice" || access.Fs != "os" || access.Params["basePath"] != "/tmp/alice" {
+		t.Fatalf("unexpected access: %+v", access)
+	}
+
+	if !access.ReadOnly || access.Shared {
+		t.Fatalf("unexpected flags: read_only=%v shared=%v", access.ReadOnly, access.Shared)
+	}
+
+	if _, err := store.GetAccess("bob", "plain"); err != nil {
+		t.Fatalf("couldn't authenticate with a plain password: %v", err)
+	}
+
+	if _, err := store.GetAccess("alice", "wrong"); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("wrong password should be rejected, got %v", err)
+	}
+
+	if _, err := store.GetAccess("carol", "secret"); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("unknown user should be rejected, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	store, db := newTestStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	if _, err := store.GetAccess("alice", "secret"); err != nil {
+		t.Fatalf("couldn't authenticate: %v", err)
+	}
+
+	if _, err := store.GetAccess("carol", "pass"); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("unknown user should be rejected, got %v", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM users WHERE user = 'alice'"); err != nil {
+		t.Fatalf("couldn't delete user: %v", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users VALUES ('carol', 'pass', 'os', NULL, 0, 0)"); err != nil {
+		t.Fatalf("couldn't insert user: %v", err)
+	}
+
+	// Both results are still cached
+	if _, err := store.GetAccess("alice", "secret"); err != nil {
+		t.Fatalf("cached user should be accepted: %v", err)
+	}
+
+	if _, err := store.GetAccess("carol", "pass"); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("cached unknown user should be rejected, got %v", err)
+	}
+
+	now = now.Add(DefaultCacheTTL)
+
+	if _, err := store.GetAccess("alice", "secret"); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("deleted user should be rejected, got %v", err)
+	}
+
+	if _, err := store.GetAccess("carol", "pass"); err != nil {
+		t.Fatalf("new user should be accepted: %v", err)
+	}
+}
+
+func TestUnsupportedDriver(t *testing.T) {
+	if _, err := NewStore(&confpar.SQL{Driver: "oracle"}); !errors.Is(err, ErrUnsupportedDriver) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+// Package webhook gets the accesses of the users from an HTTP webhook
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Default settings
+const (
+	DefaultTimeout    = 10 * time.Second
+	DefaultRetryDelay = 200 * time.Millisecond
+)
+
+// Signature headers, of the requests and of the responses
+const (
+	TimestampHeader = "X-Ftpserver-Timestamp"
+	SignatureHeader = "X-Ftpserver-Signature"
+)
+
+// MaxSignatureAge is the maximum difference between the timestamp of a signed response and the current time
+const MaxSignatureAge = 5 * time.Minute
+
+// ErrInvalidCredentials is returned when the webhook refuses the user
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUnexpectedStatus is returned when the webhook answers with an unexpected status code
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// ErrInvalidSignature is returned when the response signature is missing, wrong or too old
+var ErrInvalidSignature = errors.New("invalid webhook response signature")
+
+// ErrUnreachable is returned when the webhook couldn't be reached
+var ErrUnreachable = errors.New("webhook unreachable")
+
+// ErrInvalidCA is returned when the CA bundle doesn't contain any certificate
+var ErrInvalidCA = errors.New("no certificate found in the CA bundle")
+
+// ErrNoSecret is returned when the responses must be verified without a secret
+var ErrNoSecret = errors.New("verify_response requires a secret")
+
+// Request is the payload sent to the webhook
+type Request struct {
+	User     string `json:"user"`      // User name
+	Pass     string `json:"pass"`      // Password
+	RemoteIP string `json:"remote_ip"` // IP of the client
+	ClientID uint32 `json:"client_id"` // ID of the client connection
+	TLS      bool   `json:"tls"`       // Whether the control connection is over TLS
+}
+
+// cacheEntry is a granted access
+type cacheEntry struct {
+	access  *confpar.Access
+	expires time.Time
+}
+
+// Client calls the webhook, and caches its answers
+type Client struct {
+	conf     *confpar.AccessesWebhook
+	client   *http.Client
+	cacheKey []byte // Key of the credentials hashes, so that the cache doesn't hold guessable hashes
+	mu       sync.Mutex
+	cache    map[string]*cacheEntry
+	now      func() time.Time
+}
+
+// NewClient creates a webhook client
+func NewClient(conf *confpar.AccessesWebhook) (*Client, error) {
+	if conf.VerifyResponse && conf.Secret == "" {
+		return nil, ErrNoSecret
+	}
+
+	tlsConf, err := tlsConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := make([]byte, 32)
+	if _, err := rand.Read(cacheKey); err != nil {
+		return nil, fmt.Errorf("could not generate cache key: %w", err)
+	}
+
+	return &Client{
+		conf:     conf,
+		client:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}},
+		cacheKey: cacheKey,
+		cache:    make(map[string]*cacheEntry),
+		now:      time.Now,
+	}, nil
+}
+
+// tlsConfig returns the TLS config used to call the webhook
+func tlsConfig(conf *confpar.AccessesWebhook) (*tls.Config, error) {
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if conf.CA != "" {
+		caBytes, err := os.ReadFile(conf.CA)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA file: %s: %w", conf.CA, err)
+		}
+
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, conf.CA)
+		}
+	}
+
+	if conf.ClientCert != "" || conf.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCert, conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
+
+// Sign returns the signature of a body, sent at a given unix timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignResponse returns the signature of a response body, sent at a given unix timestamp. It also covers the
+// signature of the request it answers, so that a response can't be replayed for another request.
+func SignResponse(secret, timestamp, requestSignature string, body []byte) string {
+	return Sign(secret, timestamp+"."+requestSignature, body)
+}
+
+// verify checks the signature of a response to a request signed with requestSignature
+func (c *Client) verify(header http.Header, requestSignature string, body []byte) error {
+	timestamp := header.Get(TimestampHeader)
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp", ErrInvalidSignature)
+	}
+
+	if age := c.now().Sub(time.Unix(seconds, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return fmt.Errorf("%w: expired timestamp", ErrInvalidSignature)
+	}
+
+	expected := SignResponse(c.conf.Secret, timestamp, requestSignature, body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Config returns the config the client was created with
+func (c *Client) Config() *confpar.AccessesWebhook {
+	return c.conf
+}
+
+// GetAccess returns the access granted by the webhook
+func (c *Client) GetAccess(req *Request) (*confpar.Access, error) {
+	key := c.key(req)
+
+	if access := c.cached(key); access != nil {
+		return access, nil
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	delay := c.conf.RetryDelay.Duration
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	var access *confpar.Access
+
+	for attempt := 0; ; attempt++ {
+		access, err = c.call(payload)
+		if err == nil || !retryable(err) || attempt >= c.conf.Retries {
+			break
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The webhook doesn't have to repeat the user name
+	if access.User == "" {
+		access.User = req.User
+	}
+
+	c.store(key, access)
+
+	return access, nil
+}
+
+// key returns the cache key of a request, the password is only kept as a keyed hash
+func (c *Client) key(req *Request) string {
+	mac := hmac.New(sha256.New, c.cacheKey)
+	_, _ = fmt.Fprintf(mac, "%s\x00%s\x00%v", req.Pass, req.RemoteIP, req.TLS)
+
+	return req.User + "\x00" + string(mac.Sum(nil))
+}
+
+// cached returns a copy of a cached access, nil if there's none
+func (c *Client) cached(key string) *confpar.Access {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.cache[key]
+	if entry == nil || !entry.expires.After(c.now()) {
+		return nil
+	}
+
+	access := *entry.access
+
+	return &access
+}
+
+// store caches a copy of an access
+func (c *Client) store(key string, access *confpar.Access) {
+	ttl := c.conf.CacheTTL.Duration
+	if ttl <= 0 {
+		return
+	}
+
+	now := c.now()
+	cached := *access
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Expired entries are dropped as we go, to keep the cache small
+	for k, entry := range c.cache {
+		if !entry.expires.After(now) {
+			delete(c.cache, k)
+		}
+	}
+
+	c.cache[key] = &cacheEntry{access: &cached, expires: now.Add(ttl)}
+}
+
+// retryable tells if a call error might be temporary
+func retryable(err error) bool {
+	var status *statusError
+
+	if errors.As(err, &status) {
+		return status.code >= http.StatusInternalServerError || status.code == http.StatusTooManyRequests
+	}
+
+	return !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrInvalidSignature)
+}
+
+// Unavailable tells if an error comes from the webhook being down: unreachable or answering with a server error.
+// Any other error, like a wrong signature, is an answer that can't be trusted.
+func Unavailable(err error) bool {
+	var status *statusError
+
+	if errors.As(err, &status) {
+		return status.code >= http.StatusInternalServerError
+	}
+
+	return errors.Is(err, ErrUnreachable)
+}
+
+// statusError is an unexpected status code
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %d", ErrUnexpectedStatus, e.code)
+}
+
+func (e *statusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// call does a single webhook request
+func (c *Client) call(payload []byte) (*confpar.Access, error) {
+	timeout := c.conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	// Timeout is implemented with context termination
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.conf.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	var signature string
+
+	if c.conf.Secret != "" {
+		timestamp := strconv.FormatInt(c.now().Unix(), 10)
+		signature = Sign(c.conf.Secret, timestamp, payload)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, signature)
+	}
+
+	for key, value := range c.conf.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, &statusError{code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	if c.conf.VerifyResponse {
+		if err := c.verify(resp.Header, signature, body); err != nil {
+			return nil, err
+		}
+	}
+
+	access := new(confpar.Access)
+	if err := json.Unmarshal(body, access); err != nil {
+		return nil, fmt.Errorf("could not parse webhook response: %w", err)
+	}
+
+	return access, nil
+}
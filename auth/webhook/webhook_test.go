@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func newTestClient(t *testing.T, conf *confpar.AccessesWebhook) *Client {
+	t.Helper()
+
+	client, err := NewClient(conf)
+	if err != nil {
+		t.Fatalf("couldn't create client: %v", err)
+	}
+
+	return client
+}
+
+func TestGetAccess(t *testing.T) {
+	var received Request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+
+		if received.Pass != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"fs": "os", "params": {"basePath": "/tmp"}}`))
+	}))
+	defer srv.Close()
+
+	client := newTestClient(t, &confpar.AccessesWebhook{URL: srv.URL})
+
+	access, err := client.GetAccess(&Request{User: "alice", Pass: "secret", RemoteIP: "10.0.0.1", ClientID: 3, TLS: true})
+	if err != nil {
+		t.Fatalf("couldn't get access: %v", err)
+	}
+
+	if access.User != "alice" || access.Fs != "os" {
+		t.Fatalf("unexpected access: %+v", access)
+	}
+
+	if received.RemoteIP != "10.0.0.1" || received.ClientID != 3 || !received.TLS {
+		t.Fatalf("unexpected payload: %+v", received)
+	}
+
+	if _, err := client.GetAccess(&Request{User: "alice", Pass: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	defer srv.Close()
+
+	client := newTestClient(t, &confpar.AccessesWebhook{
+		URL:      srv.URL,
+		CacheTTL: confpar.Duration{Duration: time.Minute},
+	})
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	for range 2 {
+		if _, err := client.GetAccess(&Request{User: "alice", Pass: "secret"}); err != nil {
+			t.Fatalf("couldn't get access: %v", err)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Fatalf("access should be cached, got %d calls", calls.Load())
+	}
+
+	// Another password isn't served from the cache
+	if _, err := client.GetAccess(&Request{User: "alice", Pass: "other"}); err != nil {
+		t.Fatalf("couldn't get access: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, err := client.GetAccess(&Request{User: "alice", Pass: "secret"}); err != nil {
+		t.Fatalf("couldn't get access: %v", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	defer srv.Close()
+
+	client := newTestClient(t, &confpar.AccessesWebhook{
+		URL:        srv.URL,
+		Retries:    1,
+		RetryDelay: confpar.Duration{Duration: time.Millisecond},
+	})
+
+	if _, err := client.GetAccess(&Request{User: "alice"}); !errors.Is(err, ErrUnexpectedStatus) {
+		t.Fatalf("expected ErrUnexpectedStatus, got %v", err)
+	}
+
+	client.conf.Retries = 2
+
+	calls.Store(0)
+
+	if _, err := client.GetAccess(&Request{User: "alice"}); err != nil {
+		t.Fatalf("couldn't get access after retries: %v", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestSignature(t *testing.T) {
+	const secret = "s3cr3t"
+
+	signResponse := true
+
+	var replayed http.Header // Headers of a previous response, replayed as they are
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		requestSignature := r.Header.Get(SignatureHeader)
+		if requestSignature != Sign(secret, r.Header.Get(TimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		response := []byte(`{"fs": "os"}`)
+
+		switch {
+		case replayed != nil:
+			w.Header().Set(TimestampHeader, replayed.Get(TimestampHeader))
+			w.Header().Set(SignatureHeader, replayed.Get(SignatureHeader))
+		case signResponse:
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			w.Header().Set(TimestampHeader, timestamp)
+			w.Header().Set(SignatureHeader, SignResponse(secret, timestamp, requestSignature, response))
+		}
+
+		_, _ = w.Write(response)
+	}))
+	defer srv.Close()
+
+	client := newTestClient(t, &confpar.AccessesWebhook{URL: srv.URL, Secret: secret, VerifyResponse: true})
+
+	if _, err := client.GetAccess(&Request{User: "alice"}); err != nil {
+		t.Fatalf("couldn't get access: %v", err)
+	}
+
+	// A valid response to another request is refused
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	requestSignature := Sign(secret, timestamp, []byte(`{"user":"alice"}`))
+	replayed = http.Header{}
+	replayed.Set(TimestampHeader, timestamp)
+	replayed.Set(SignatureHeader, SignResponse(secret, timestamp, requestSignature, []byte(`{"fs": "os"}`)))
+
+	if _, err := client.GetAccess(&Request{User: "bob"}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature for a replayed response, got %v", err)
+	}
+
+	replayed = nil
+	signResponse = false
+
+	if _, err := client.GetAccess(&Request{User: "alice"}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	// A response signed too far from the current time is refused
+	signResponse = true
+	client.now = func() time.Time { return time.Now().Add(2 * MaxSignatureAge) }
+
+	if _, err := client.GetAccess(&Request{User: "alice"}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	if _, err := NewClient(&confpar.AccessesWebhook{URL: srv.URL, VerifyResponse: true}); !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("expected ErrNoSecret, got %v", err)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+func writeClientCert(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ftpserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldn't create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("couldn't marshal key: %v", err)
+	}
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDer)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("couldn't parse certificate: %v", err)
+	}
+
+	return cert
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("couldn't write %s: %v", file, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	clientCert := writeClientCert(t, certFile, keyFile)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	srv.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	client := newTestClient(t, &confpar.AccessesWebhook{URL: srv.URL, CA: caFile, ClientCert: certFile, ClientKey: keyFile})
+	if _, err := client.GetAccess(&Request{User: "alice"}); err != nil {
+		t.Fatalf("couldn't get access: %v", err)
+	}
+
+	// Without the client certificate, the handshake fails
+	client = newTestClient(t, &confpar.AccessesWebhook{URL: srv.URL, CA: caFile})
+	if _, err := client.GetAccess(&Request{User: "alice"}); err == nil {
+		t.Fatal("the webhook should require a client certificate")
+	}
+
+	if _, err := NewClient(&confpar.AccessesWebhook{URL: srv.URL, CA: keyFile}); !errors.Is(err, ErrInvalidCA) {
+		t.Fatalf("expected ErrInvalidCA, got %v", err)
+	}
+}
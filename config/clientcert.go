@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrSkipPasswordTOTP is returned when an access could skip its TOTP second factor with a client certificate
+var ErrSkipPasswordTOTP = errors.New("client_cert skip_password can't be used with a totp_secret")
+
+// ErrSkipPasswordSource is returned when skip_password is set on an access it can't apply to
+var ErrSkipPasswordSource = errors.New("client_cert skip_password only applies to the accesses of the config file")
+
+// checkClientCerts rejects the client certificate settings that wouldn't be enforced as configured. The certificate
+// logins only look for the user in the accesses of the config file, and they don't ask for any TOTP code.
+func (c *Config) checkClientCerts() error {
+	for _, access := range c.Content.Accesses {
+		if skipPassword(c.merged(access)) && access.TOTPSecret != "" {
+			return fmt.Errorf("%w: %s", ErrSkipPasswordTOTP, access.User)
+		}
+	}
+
+	if c.Content.LDAP != nil {
+		for _, group := range c.Content.LDAP.Groups {
+			if group.Access != nil && skipPassword(c.merged(group.Access)) {
+				return fmt.Errorf("%w: ldap group %s", ErrSkipPasswordSource, group.Group)
+			}
+		}
+	}
+
+	return nil
+}
+
+// merged returns an access merged with its template, if it can be found
+func (c *Config) merged(access *confpar.Access) *confpar.Access {
+	if template := c.Content.AccessTemplates[access.Template]; access.Template != "" && template != nil {
+		return mergeAccess(template, access)
+	}
+
+	return access
+}
+
+func skipPassword(access *confpar.Access) bool {
+	return access.ClientCert != nil && access.ClientCert.SkipPassword
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestCheckClientCerts(t *testing.T) {
+	skipPassword := &confpar.ClientCert{SkipPassword: true}
+
+	for name, item := range map[string]struct {
+		content *confpar.Content
+		want    error
+	}{
+		"certificate only": {
+			content: &confpar.Content{Accesses: []*confpar.Access{{User: "robot", ClientCert: skipPassword}}},
+		},
+		"certificate and password with TOTP": {
+			content: &confpar.Content{Accesses: []*confpar.Access{
+				{User: "alice", TOTPSecret: "JBSWY3DPEHPK3PXP", ClientCert: &confpar.ClientCert{CommonName: "alice"}},
+			}},
+		},
+		"certificate only with TOTP": {
+			content: &confpar.Content{Accesses: []*confpar.Access{
+				{User: "alice", TOTPSecret: "JBSWY3DPEHPK3PXP", ClientCert: skipPassword},
+			}},
+			want: ErrSkipPasswordTOTP,
+		},
+		"certificate only from a template with TOTP": {
+			content: &confpar.Content{
+				AccessTemplates: map[string]*confpar.Access{"robots": {ClientCert: skipPassword}},
+				Accesses: []*confpar.Access{
+					{User: "alice", Template: "robots", TOTPSecret: "JBSWY3DPEHPK3PXP"},
+				},
+			},
+			want: ErrSkipPasswordTOTP,
+		},
+		"certificate only on LDAP": {
+			content: &confpar.Content{LDAP: &confpar.LDAP{Groups: []*confpar.LDAPGroup{
+				{Access: &confpar.Access{Fs: "os", ClientCert: skipPassword}},
+			}}},
+			want: ErrSkipPasswordSource,
+		},
+	} {
+		_, err := FromContent(item.content, "test.json", slog.Default())
+		if item.want == nil && err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		if item.want != nil && !errors.Is(err, item.want) {
+			t.Fatalf("%s: expected %v, got: %v", name, item.want, err)
+		}
+	}
+}
@@ -2,18 +2,17 @@
 package config
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"sync"
 
 	"github.com/fclairamb/ftpserver/config/confpar"
 	"github.com/fclairamb/ftpserver/fs"
 
-	"github.com/go-crypt/crypt"
-	"github.com/go-crypt/crypt/algorithm/bcrypt"
 	"github.com/tidwall/sjson"
 )
 
@@ -25,6 +24,9 @@ type Config struct {
 	fileName string
 	logger   *slog.Logger
 	Content  *confpar.Content
+	totp     totpTracker  // TOTP codes replay protection
+	saveSync sync.Mutex   // Changes of the config file
+	accSync  sync.RWMutex // Replacements of the accesses while logging in
 }
 
 // NewConfig creates a new config instance
@@ -103,6 +105,11 @@ func (c *Config) HashPlaintextPasswords() error {
 		return errReadFile
 	}
 
+	policy, err := getHashPolicy(c.Content.PasswordHashing)
+	if err != nil {
+		return err
+	}
+
 	save := false
 	for i, a := range c.Content.Accesses {
 		if a.User == "anonymous" && a.Pass == "*" {
@@ -110,44 +117,17 @@ func (c *Config) HashPlaintextPasswords() error {
 		}
 
 		switch true {
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$1$")):
-			//This user's password is md5crypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2$")):
-			//This user's password is bcrypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2a$")):
-			//This user's password is bcrypt-a
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2b$")):
-			//This user's password is bcrypt-b
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2x$")):
-			//This user's password is bcrypt-x
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2y$")):
-			//This user's password is bcrypt-y
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$5$")):
-			//This user's password is sha256crypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$6$")):
-			//This user's password is sha512crypt
+		case IsHashed(a.Pass):
 			continue
 		default:
 			//This password is not hashed
-			hasher, err := bcrypt.New(bcrypt.WithCost(10))
+			digest, err := policy.hash(a.Pass)
 			if err != nil {
 				return err
 			}
 
-			digest, err := hasher.Hash(a.Pass)
-			if err != nil {
-				return err
-			}
-
-			modified, errJsonSet := sjson.Set(string(json), "accesses."+fmt.Sprint(i)+".pass", digest.Encode())
-			c.Content.Accesses[i].Pass = digest.Encode()
+			modified, errJsonSet := sjson.Set(string(json), "accesses."+fmt.Sprint(i)+".pass", digest)
+			c.Content.Accesses[i].Pass = digest
 			if errJsonSet == nil {
 				save = true
 				json = []byte(modified)
@@ -175,7 +155,7 @@ func (c *Config) Prepare() error {
 		ct.PublicHost = publicHost
 	}
 
-	return nil
+	return c.checkClientCerts()
 }
 
 // CheckAccesses checks all accesses
@@ -192,14 +172,45 @@ func (c *Config) CheckAccesses() error {
 	return nil
 }
 
+// accesses returns the accesses of the config file. They are never modified once loaded: the logins replace them
+// with updated copies, so that the accesses being used by other logins don't change.
+func (c *Config) accesses() []*confpar.Access {
+	c.accSync.RLock()
+	defer c.accSync.RUnlock()
+
+	return c.Content.Accesses
+}
+
+// replaceAccess replaces an access with an updated copy, in a new list of accesses
+func (c *Config) replaceAccess(index int, access *confpar.Access) {
+	c.accSync.Lock()
+	defer c.accSync.Unlock()
+
+	accesses := slices.Clone(c.Content.Accesses)
+	accesses[index] = access
+	c.Content.Accesses = accesses
+}
+
+// GetAccessByUser returns the access of a user, without checking any credentials
+func (c *Config) GetAccessByUser(user string) (*confpar.Access, error) {
+	for _, a := range c.accesses() {
+		if a.User == user {
+			return a, nil
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
 // GetAccess return a file system access given some credentials
 func (c *Config) GetAccess(user string, pass string) (*confpar.Access, error) {
-	decoder, err := crypt.NewDecoderAll()
-	if err != nil {
-		return nil, err
-	}
+	return c.GetClientAccess(nil, user, pass)
+}
 
-	for _, a := range c.Content.Accesses {
+// GetClientAccess returns a file system access given some credentials sent by a client. Without any client, the
+// TOTP codes must be sent along with the passwords.
+func (c *Config) GetClientAccess(client *LoginClient, user string, pass string) (*confpar.Access, error) {
+	for _, a := range c.accesses() {
 		if a.Fs == "keycloak" {
 			a.User = user
 			a.Pass = pass
@@ -207,132 +218,30 @@ func (c *Config) GetAccess(user string, pass string) (*confpar.Access, error) {
 		}
 
 		if a.User == user {
-			switch true {
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$1$")):
-				//This user's password is md5crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2$")):
-				//This user's password is bcrypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2a$")):
-				//This user's password is bcrypt-a
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2b$")):
-				//This user's password is bcrypt-b
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2x$")):
-				//This user's password is bcrypt-x
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2y$")):
-				//This user's password is bcrypt-y
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$5$")):
-				//This user's password is sha256crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
+			if a.User == "anonymous" && a.Pass == "*" {
+				return a, nil
+			}
 
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
+			var (
+				ok  bool
+				err error
+			)
 
+			if a.TOTPSecret != "" {
+				ok, err = c.checkTOTP(client, a, pass)
+			} else {
+				ok, err = MatchPassword(a.Pass, pass)
 				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$6$")):
-				//This user's password is sha512crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
+					c.rehashPassword(a, pass)
 				}
+			}
 
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
+			if err != nil {
+				return nil, err
+			}
 
-				if ok {
-					return a, nil
-				}
-			default:
-				//This user's password is plain-text
-				if a.Pass == pass || (a.User == "anonymous" && a.Pass == "*") {
-					return a, nil
-				}
+			if ok {
+				return a, nil
 			}
 		}
 
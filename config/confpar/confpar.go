@@ -3,26 +3,94 @@ package confpar
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 )
 
 // Access provides rules around any access
 type Access struct {
-	User          string            `json:"user"`            // User authenticating
-	Pass          string            `json:"pass"`            // Password used for authentication
-	Fs            string            `json:"fs"`              // Backend used for accessing file
-	Params        map[string]string `json:"params"`          // Backend parameters
-	Logging       Logging           `json:"logging"`         // Logging parameters
-	ReadOnly      bool              `json:"read_only"`       // Read-only access
-	Shared        bool              `json:"shared"`          // Shared FS instance
-	SyncAndDelete *SyncAndDelete    `json:"sync_and_delete"` // Local empty directory and synchronization
+	User            string            `json:"user"`              // User authenticating
+	Template        string            `json:"template"`          // Access template this access is based on
+	Attributes      map[string]string `json:"attributes"`        // Attributes usable in the params templates
+	Pass            string            `json:"pass"`              // Password used for authentication
+	TOTPSecret      string            `json:"totp_secret"`       // Base32 TOTP secret, as a second factor
+	Fs              string            `json:"fs"`                // Backend used for accessing file
+	Params          map[string]string `json:"params"`            // Backend parameters
+	Logging         Logging           `json:"logging"`           // Logging parameters
+	ReadOnly        bool              `json:"read_only"`         // Read-only access
+	Shared          bool              `json:"shared"`            // Shared FS instance
+	SyncAndDelete   *SyncAndDelete    `json:"sync_and_delete"`   // Local empty directory and synchronization
+	MaxSessions     int               `json:"max_sessions"`      // Maximum concurrent sessions for this access
+	MaxDownloadRate int               `json:"max_download_rate"` // Maximum download rate (bytes/s) for this access
+	MaxUploadRate   int               `json:"max_upload_rate"`   // Maximum upload rate (bytes/s) for this access
+	Quota           *Quota            `json:"quota"`             // Storage quota
+	ACL             []*ACLRule        `json:"acl"`               // Path-based access rules
+	Mounts          []*Mount          `json:"mounts"`            // File systems mounted in the access tree
+	ClientCert      *ClientCert       `json:"client_cert"`       // Client certificate required by this access
+	AllowedIPs      []IPRange         `json:"allowed_ips"`       // IPs or CIDR ranges allowed to use this access
+	DeniedIPs       []IPRange         `json:"denied_ips"`        // IPs or CIDR ranges denied from using this access
+}
+
+// ClientCert defines the client certificate an access requires. When neither the common name nor the
+// SAN is set, the certificate's common name must be the user name.
+type ClientCert struct {
+	CommonName   string `json:"common_name"`   // Expected subject common name
+	SAN          string `json:"san"`           // Expected subject alternative name (DNS name, email, IP or URI)
+	SkipPassword bool   `json:"skip_password"` // Authenticate with the certificate alone
+}
+
+// Mount defines a file system mounted on a path of an access
+type Mount struct {
+	Path     string            `json:"path"`      // Path where the file system is mounted
+	Fs       string            `json:"fs"`        // Backend used for accessing file
+	Params   map[string]string `json:"params"`    // Backend parameters
+	ReadOnly bool              `json:"read_only"` // Read-only mount
+}
+
+// ACLRule defines the operations allowed or denied on some paths. Rules are evaluated in order and
+// the first matching rule mentioning an operation decides if it is allowed.
+type ACLRule struct {
+	Path  string   `json:"path"`  // Path pattern, "*" matches within a path element and "**" any number of them
+	Allow []string `json:"allow"` // Allowed operations
+	Deny  []string `json:"deny"`  // Denied operations
+}
+
+// Quota defines the storage limits of an access
+type Quota struct {
+	MaxBytes  int64  `json:"max_bytes"`  // Maximum bytes that can be stored
+	MaxFiles  int64  `json:"max_files"`  // Maximum files that can be stored
+	StateFile string `json:"state_file"` // File where the usage is persisted
 }
 
 // AccessesWebhook defines an optional webhook to get user's access
 type AccessesWebhook struct {
-	URL     string            `json:"url"`     // URL to call
-	Headers map[string]string `json:"headers"` // Token to use in the
-	Timeout Duration          `json:"timeout"` // Max time request can take
+	URL                string            `json:"url"`                  // URL to call
+	Headers            map[string]string `json:"headers"`              // Token to use in the
+	Timeout            Duration          `json:"timeout"`              // Max time request can take
+	CacheTTL           Duration          `json:"cache_ttl"`            // Duration the granted accesses are cached
+	Retries            int               `json:"retries"`              // Number of retries on failure
+	RetryDelay         Duration          `json:"retry_delay"`          // Delay before the first retry, doubled on each retry
+	FallbackToAccesses bool              `json:"fallback_to_accesses"` // Use the accesses when the webhook is down
+	Secret             string            `json:"secret"`               // Secret used to sign the requests
+	VerifyResponse     bool              `json:"verify_response"`      // Require a valid signature on the responses
+	CA                 string            `json:"ca"`                   // CA bundle used to verify the server certificate
+	ClientCert         string            `json:"client_cert"`          // Client certificate
+	ClientKey          string            `json:"client_key"`           // Client certificate key
+}
+
+// EventHook defines a webhook or a local command called on some events
+type EventHook struct {
+	On         []string          `json:"on"`          // Events triggering the hook
+	URL        string            `json:"url"`         // URL to POST the event to
+	Headers    map[string]string `json:"headers"`     // Headers of the HTTP request
+	Command    string            `json:"command"`     // Command receiving the event on its standard input
+	Args       []string          `json:"args"`        // Arguments of the command
+	Timeout    Duration          `json:"timeout"`     // Max time the hook can take
+	Retries    int               `json:"retries"`     // Number of retries on failure
+	RetryDelay Duration          `json:"retry_delay"` // Delay before the first retry, doubled on each retry
 }
 
 // SyncAndDelete provides
@@ -48,6 +116,8 @@ type Logging struct {
 // TLS define the TLS Config
 type TLS struct {
 	ServerCert *ServerCert `json:"server_cert"` // Server certificates
+	ClientCA   string      `json:"client_ca"`   // CA bundle used to verify the client certificates
+	ClientAuth string      `json:"client_auth"` // Client certificate requirement: none, optional or required
 }
 
 // ServerCert defines the TLS server certificate config
@@ -56,6 +126,82 @@ type ServerCert struct {
 	Key  string `json:"key"`  // Private key
 }
 
+// Metrics defines the HTTP endpoint exposing the metrics
+type Metrics struct {
+	ListenAddress string `json:"listen_address"` // Address to listen on
+}
+
+// BruteForce defines the protection against brute-force attacks: an IP or a user reaching max_attempts
+// failed logins within window is banned for ban_duration.
+type BruteForce struct {
+	MaxAttempts int      `json:"max_attempts"` // Failed logins before a ban
+	Window      Duration `json:"window"`       // Period over which the failed logins are counted
+	BanDuration Duration `json:"ban_duration"` // Duration of the bans
+	Delay       Duration `json:"delay"`        // Delay before answering a failed login, doubled on each failure
+	MaxDelay    Duration `json:"max_delay"`    // Maximum delay before answering a failed login
+	StateFile   string   `json:"state_file"`   // File where the bans are persisted
+}
+
+// ProxyProtocol defines which sources must send a PROXY protocol header on the control connection
+type ProxyProtocol struct {
+	TrustedSources []IPRange `json:"trusted_sources"` // IPs or CIDR ranges of the proxies
+}
+
+// LDAP defines an LDAP or Active Directory authentication source
+type LDAP struct {
+	URL                string       `json:"url"`                  // Server URL, ldap:// or ldaps://
+	StartTLS           bool         `json:"start_tls"`            // Upgrade the ldap:// connections with StartTLS
+	CA                 string       `json:"ca"`                   // CA bundle used to verify the server certificate
+	InsecureSkipVerify bool         `json:"insecure_skip_verify"` // Don't verify the server certificate
+	BindDN             string       `json:"bind_dn"`              // DN used to search the users, anonymous if empty
+	BindPassword       string       `json:"bind_password"`        // Password of the bind DN
+	BaseDN             string       `json:"base_dn"`              // Where the users are searched
+	UserFilter         string       `json:"user_filter"`          // Search filter, {{.User}} being the escaped user name
+	LoginAttribute     string       `json:"login_attribute"`      // Attribute holding the canonical user name
+	GroupAttribute     string       `json:"group_attribute"`      // Attribute listing the groups of a user
+	Groups             []*LDAPGroup `json:"groups"`               // Accesses of the groups, the first matching one is used
+	Timeout            Duration     `json:"timeout"`              // Max time the LDAP exchanges can take
+}
+
+// LDAPGroup gives an access to the members of an LDAP group
+type LDAPGroup struct {
+	Group  string  `json:"group"`  // DN of the group, all the users match when empty
+	Access *Access `json:"access"` // Access template, its params can use the LDAP attributes like {{.uid}}
+}
+
+// PasswordHashing defines the algorithm and costs used to hash the passwords
+type PasswordHashing struct {
+	Algorithm         string `json:"algorithm"`          // bcrypt, argon2id, scrypt or sha512crypt
+	BcryptCost        int    `json:"bcrypt_cost"`        // bcrypt cost
+	Argon2Memory      int    `json:"argon2_memory"`      // argon2id memory, in KiB
+	Argon2Iterations  int    `json:"argon2_iterations"`  // argon2id iterations
+	Argon2Parallelism int    `json:"argon2_parallelism"` // argon2id parallelism
+	ScryptLN          int    `json:"scrypt_ln"`          // scrypt log2 of the CPU/memory cost
+	ScryptR           int    `json:"scrypt_r"`           // scrypt block size
+	ScryptP           int    `json:"scrypt_p"`           // scrypt parallelism
+	SHA512CryptRounds int    `json:"sha512crypt_rounds"` // sha512crypt rounds
+	RehashOnLogin     bool   `json:"rehash_on_login"`    // Re-hash the passwords weaker than the policy on login
+}
+
+// SQL defines a SQL database authentication source
+type SQL struct {
+	Driver           string   `json:"driver"`             // Database driver: sqlite or postgres
+	DSN              string   `json:"dsn"`                // Data source name
+	Query            string   `json:"query"`              // Query returning user, pass, fs, params, read_only and shared
+	MaxOpenConns     int      `json:"max_open_conns"`     // Maximum open connections
+	MaxIdleConns     int      `json:"max_idle_conns"`     // Maximum idle connections
+	ConnMaxLifetime  Duration `json:"conn_max_lifetime"`  // Maximum lifetime of a connection
+	Timeout          Duration `json:"timeout"`            // Max time a query can take
+	CacheTTL         Duration `json:"cache_ttl"`          // Duration the found users are cached
+	NegativeCacheTTL Duration `json:"negative_cache_ttl"` // Duration the unknown users are cached
+}
+
+// Admin defines the HTTP admin API
+type Admin struct {
+	ListenAddress string `json:"listen_address"` // Address to listen on
+	Token         string `json:"token"`          // Bearer token required to use the API
+}
+
 // Extensions define the relevant configurations for extended features
 type Extensions struct {
 	EnableHASH bool `json:"enable_hash"` // Enable support for calculating hash value of files
@@ -63,19 +209,33 @@ type Extensions struct {
 
 // Content defines the content of the config file
 type Content struct {
-	Version                  int              `json:"version"`                     // File format version
-	ListenAddress            string           `json:"listen_address"`              // Address to listen on
-	PublicHost               string           `json:"public_host"`                 // Public host to listen on
-	MaxClients               int              `json:"max_clients"`                 // Maximum clients who can connect
-	HashPlaintextPasswords   bool             `json:"hash_plaintext_passwords"`    // Overwrite plain-text passwords with hashed equivalents
-	IdleTimeout              Duration         `json:"idle_timeout"`                // Maximum idle time for client connections
-	Accesses                 []*Access        `json:"accesses"`                    // Accesses offered to users
-	PassiveTransferPortRange *PortRange       `json:"passive_transfer_port_range"` // Listen port range
-	Extensions               Extensions       `json:"extensions"`                  // Extended features
-	Logging                  Logging          `json:"logging"`                     // Logging parameters
-	TLS                      *TLS             `json:"tls"`                         // TLS Config
-	TLSRequired              string           `json:"tls_required"`
-	AccessesWebhook          *AccessesWebhook `json:"accesses_webhook"` // Webhook to call when accesses are updated
+	Version                  int                `json:"version"`                     // File format version
+	ListenAddress            string             `json:"listen_address"`              // Address to listen on
+	PublicHost               string             `json:"public_host"`                 // Public host to listen on
+	MaxClients               int                `json:"max_clients"`                 // Maximum clients who can connect
+	MaxSessionsPerIP         int                `json:"max_sessions_per_ip"`         // Maximum sessions per remote IP
+	MaxDownloadRate          int                `json:"max_download_rate"`           // Maximum global download rate (bytes/s)
+	MaxUploadRate            int                `json:"max_upload_rate"`             // Maximum global upload rate (bytes/s)
+	HashPlaintextPasswords   bool               `json:"hash_plaintext_passwords"`    // Overwrite plain-text passwords with hashed equivalents
+	IdleTimeout              Duration           `json:"idle_timeout"`                // Maximum idle time for client connections
+	Accesses                 []*Access          `json:"accesses"`                    // Accesses offered to users
+	PassiveTransferPortRange *PortRange         `json:"passive_transfer_port_range"` // Listen port range
+	Extensions               Extensions         `json:"extensions"`                  // Extended features
+	Logging                  Logging            `json:"logging"`                     // Logging parameters
+	TLS                      *TLS               `json:"tls"`                         // TLS Config
+	TLSRequired              string             `json:"tls_required"`
+	AccessesWebhook          *AccessesWebhook   `json:"accesses_webhook"` // Webhook to call when accesses are updated
+	Events                   []*EventHook       `json:"events"`           // Hooks called on events
+	Metrics                  *Metrics           `json:"metrics"`          // Metrics endpoint
+	Admin                    *Admin             `json:"admin"`            // Admin API
+	BruteForce               *BruteForce        `json:"brute_force"`      // Brute-force protection
+	AllowedIPs               []IPRange          `json:"allowed_ips"`      // IPs or CIDR ranges allowed to connect
+	DeniedIPs                []IPRange          `json:"denied_ips"`       // IPs or CIDR ranges denied from connecting
+	ProxyProtocol            *ProxyProtocol     `json:"proxy_protocol"`   // PROXY protocol on the control connection
+	LDAP                     *LDAP              `json:"ldap"`             // LDAP authentication source
+	AccessTemplates          map[string]*Access `json:"access_templates"` // Named access templates
+	SQL                      *SQL               `json:"sql"`              // SQL authentication source
+	PasswordHashing          *PasswordHashing   `json:"password_hashing"` // How the passwords are hashed
 }
 
 // Duration wraps time.Duration to allow unmarshaling from JSON strings
@@ -95,3 +255,59 @@ func (d *Duration) UnmarshalJSON(b []byte) (err error) {
 	}
 	return
 }
+
+// ErrInvalidIPRange is returned when an IP range can't be parsed
+var ErrInvalidIPRange = errors.New("invalid IP range")
+
+// IPRange is a CIDR range or a single IP. It is parsed when the config is loaded, so that invalid entries are
+// reported at that time.
+type IPRange struct {
+	netip.Prefix
+}
+
+// ParseIPRange parses a CIDR range or a single IP
+func ParseIPRange(value string) (IPRange, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return IPRange{}, fmt.Errorf("%w: %s", ErrInvalidIPRange, value)
+		}
+
+		return IPRange{prefix.Masked()}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return IPRange{}, fmt.Errorf("%w: %s", ErrInvalidIPRange, value)
+	}
+
+	return IPRange{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+func (r IPRange) String() string {
+	if r.IsSingleIP() {
+		return r.Addr().String()
+	}
+
+	return r.Prefix.String()
+}
+
+func (r IPRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *IPRange) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseIPRange(s)
+	if err != nil {
+		return err
+	}
+
+	*r = parsed
+
+	return nil
+}
@@ -3,6 +3,7 @@ package confpar
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 )
@@ -52,3 +53,39 @@ func TestDurationUnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestIPRangeUnmarshalJSON(t *testing.T) {
+	for _, item := range []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{`10.0.0.0/8`, `10.0.0.0/8`, false},
+		{`10.1.2.3/8`, `10.0.0.0/8`, false},
+		{`10.6.6.6`, `10.6.6.6`, false},
+		{`2001:db8::/32`, `2001:db8::/32`, false},
+		{`10.0.0.0/33`, ``, true},
+		{`localhost`, ``, true},
+	} {
+		var have IPRange
+		err := json.Unmarshal([]byte(`"`+item.input+`"`), &have)
+		if err == nil && item.wantErr {
+			t.Fatalf("expecting error for %s", item.input)
+		}
+		if err != nil && !item.wantErr {
+			t.Fatalf("json.Unmarshal(): %v", err)
+		}
+		if item.wantErr && !errors.Is(err, ErrInvalidIPRange) {
+			t.Fatalf("expecting ErrInvalidIPRange, got %v", err)
+		}
+		if !item.wantErr && have.String() != item.want {
+			t.Fatalf("have:%v want:%v", have, item.want)
+		}
+	}
+
+	// An invalid entry fails the whole config
+	var content Content
+	if err := json.Unmarshal([]byte(`{"allowed_ips": ["10.0.0.0/8", "10.0.0.300"]}`), &content); !errors.Is(err, ErrInvalidIPRange) {
+		t.Fatalf("expecting ErrInvalidIPRange, got %v", err)
+	}
+}
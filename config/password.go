@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-crypt/crypt"
+	"github.com/go-crypt/crypt/algorithm"
+	"github.com/go-crypt/crypt/algorithm/argon2"
+	"github.com/go-crypt/crypt/algorithm/bcrypt"
+	"github.com/go-crypt/crypt/algorithm/scrypt"
+	"github.com/go-crypt/crypt/algorithm/shacrypt"
+	"github.com/tidwall/sjson"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Password hashing algorithms
+const (
+	HashBcrypt      = "bcrypt"
+	HashArgon2id    = "argon2id"
+	HashScrypt      = "scrypt"
+	HashSHA512Crypt = "sha512crypt"
+)
+
+// Default password hashing costs
+const (
+	DefaultBcryptCost        = 10
+	DefaultArgon2Memory      = 64 * 1024
+	DefaultArgon2Iterations  = 3
+	DefaultArgon2Parallelism = 4
+	DefaultScryptLN          = 16
+	DefaultScryptR           = 8
+	DefaultScryptP           = 1
+	DefaultSHA512CryptRounds = shacrypt.IterationsDefaultSHA512
+)
+
+// ErrUnknownHashAlgorithm is returned when the password hashing algorithm isn't supported
+var ErrUnknownHashAlgorithm = errors.New("unknown password hashing algorithm")
+
+// hashPrefixes are the prefixes of the supported password hashes
+var hashPrefixes = []string{
+	"$1$",             // md5crypt
+	"$2$",             // bcrypt
+	"$2a$",            // bcrypt-a
+	"$2b$",            // bcrypt-b
+	"$2x$",            // bcrypt-x
+	"$2y$",            // bcrypt-y
+	"$5$",             // sha256crypt
+	"$6$",             // sha512crypt
+	"$argon2id$",      // argon2id
+	"$argon2i$",       // argon2i
+	"$argon2d$",       // argon2d
+	"$scrypt$",        // scrypt
+	"$pbkdf2$",        // pbkdf2-sha1
+	"$pbkdf2-sha1$",   // pbkdf2-sha1
+	"$pbkdf2-sha224$", // pbkdf2-sha224
+	"$pbkdf2-sha256$", // pbkdf2-sha256
+	"$pbkdf2-sha384$", // pbkdf2-sha384
+	"$pbkdf2-sha512$", // pbkdf2-sha512
+}
+
+// IsHashed checks if a password is hashed with one of the supported algorithms
+func IsHashed(pass string) bool {
+	for _, prefix := range hashPrefixes {
+		if strings.HasPrefix(pass, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchPassword checks a password against a stored one, which is either hashed or in plain text
+func MatchPassword(stored, pass string) (bool, error) {
+	if !IsHashed(stored) {
+		return stored == pass, nil
+	}
+
+	decoder, err := crypt.NewDecoderAll()
+	if err != nil {
+		return false, err
+	}
+
+	digest, err := decoder.Decode(stored)
+	if err != nil {
+		return false, err
+	}
+
+	return digest.MatchAdvanced(pass)
+}
+
+// hashPolicy is the algorithm and the costs the passwords are hashed with
+type hashPolicy struct {
+	algorithm string
+	costs     []int // Costs, in the order of the hash parameters
+}
+
+// getHashPolicy returns the password hashing policy, with the defaults applied
+func getHashPolicy(conf *confpar.PasswordHashing) (*hashPolicy, error) {
+	if conf == nil {
+		conf = &confpar.PasswordHashing{}
+	}
+
+	or := func(value, defaultValue int) int {
+		if value <= 0 {
+			return defaultValue
+		}
+
+		return value
+	}
+
+	switch conf.Algorithm {
+	case "", HashBcrypt:
+		return &hashPolicy{HashBcrypt, []int{or(conf.BcryptCost, DefaultBcryptCost)}}, nil
+	case HashArgon2id:
+		return &hashPolicy{HashArgon2id, []int{
+			or(conf.Argon2Memory, DefaultArgon2Memory),
+			or(conf.Argon2Iterations, DefaultArgon2Iterations),
+			or(conf.Argon2Parallelism, DefaultArgon2Parallelism),
+		}}, nil
+	case HashScrypt:
+		return &hashPolicy{HashScrypt, []int{
+			or(conf.ScryptLN, DefaultScryptLN),
+			or(conf.ScryptR, DefaultScryptR),
+			or(conf.ScryptP, DefaultScryptP),
+		}}, nil
+	case HashSHA512Crypt:
+		return &hashPolicy{HashSHA512Crypt, []int{or(conf.SHA512CryptRounds, DefaultSHA512CryptRounds)}}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, conf.Algorithm)
+	}
+}
+
+// hasher returns the hasher of the policy
+func (p *hashPolicy) hasher() (algorithm.Hash, error) {
+	switch p.algorithm {
+	case HashArgon2id:
+		return argon2.New(
+			argon2.WithVariantID(),
+			argon2.WithM(uint32(p.costs[0])), //nolint:gosec // Positive value from the config
+			argon2.WithT(p.costs[1]),
+			argon2.WithP(p.costs[2]),
+		)
+	case HashScrypt:
+		return scrypt.New(scrypt.WithLN(p.costs[0]), scrypt.WithR(p.costs[1]), scrypt.WithP(p.costs[2]))
+	case HashSHA512Crypt:
+		return shacrypt.New(shacrypt.WithSHA512(), shacrypt.WithIterations(p.costs[0]))
+	default:
+		return bcrypt.New(bcrypt.WithCost(p.costs[0]))
+	}
+}
+
+// hash hashes a password with the policy
+func (p *hashPolicy) hash(pass string) (string, error) {
+	hasher, err := p.hasher()
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := hasher.Hash(pass)
+	if err != nil {
+		return "", err
+	}
+
+	return digest.Encode(), nil
+}
+
+// weaker tells if a stored hash uses another algorithm than the policy, or lower costs
+func (p *hashPolicy) weaker(stored string) bool {
+	algo, costs := hashCosts(stored)
+	if algo != p.algorithm || len(costs) != len(p.costs) {
+		return true
+	}
+
+	for i, cost := range costs {
+		if cost < p.costs[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashCosts returns the algorithm and the costs of a hash, in the order of the policy costs
+func hashCosts(stored string) (string, []int) {
+	parts := strings.Split(stored, "$")
+	if len(parts) < 4 { //nolint:mnd // "", identifier, parameters and more
+		return "", nil
+	}
+
+	switch parts[1] {
+	case "2", "2a", "2b", "2x", "2y":
+		cost, _ := strconv.Atoi(parts[2])
+
+		return HashBcrypt, []int{cost}
+	case "argon2id":
+		// $argon2id$v=19$m=65536,t=3,p=4$salt$key
+		params := hashParams(parts[3])
+
+		return HashArgon2id, []int{params["m"], params["t"], params["p"]}
+	case "scrypt":
+		// $scrypt$ln=16,r=8,p=1$salt$key
+		params := hashParams(parts[2])
+
+		return HashScrypt, []int{params["ln"], params["r"], params["p"]}
+	case "6":
+		// $6$rounds=500000$salt$key, the rounds default to 5000 when omitted
+		rounds := shacrypt.IterationsDefaultOmitted
+		if value, ok := strings.CutPrefix(parts[2], "rounds="); ok {
+			rounds, _ = strconv.Atoi(value)
+		}
+
+		return HashSHA512Crypt, []int{rounds}
+	default:
+		return parts[1], nil
+	}
+}
+
+// hashParams parses the "k=v,k=v" parameters of a hash
+func hashParams(text string) map[string]int {
+	params := make(map[string]int)
+
+	for _, param := range strings.Split(text, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		params[key], _ = strconv.Atoi(value)
+	}
+
+	return params
+}
+
+// rehashPassword re-hashes the password of an access when its hash is weaker than the policy
+func (c *Config) rehashPassword(access *confpar.Access, pass string) {
+	conf := c.Content.PasswordHashing
+	if conf == nil || !conf.RehashOnLogin || !IsHashed(access.Pass) {
+		return
+	}
+
+	policy, err := getHashPolicy(conf)
+	if err != nil || !policy.weaker(access.Pass) {
+		return
+	}
+
+	digest, err := policy.hash(pass)
+	if err != nil {
+		c.logger.Error("Cannot re-hash password", "err", err, "user", access.User)
+
+		return
+	}
+
+	c.saveSync.Lock()
+	defer c.saveSync.Unlock()
+
+	for i, a := range c.accesses() {
+		if a != access {
+			continue
+		}
+
+		if err := c.setInFile(fmt.Sprintf("accesses.%d.pass", i), digest); err != nil {
+			c.logger.Error("Cannot save re-hashed password", "err", err, "user", access.User)
+
+			return
+		}
+
+		updated := *access
+		updated.Pass = digest
+		c.replaceAccess(i, &updated)
+		c.logger.Info("Password re-hashed", "user", access.User, "algorithm", policy.algorithm)
+
+		return
+	}
+}
+
+// setInFile sets a value in the config file
+func (c *Config) setInFile(path string, value string) error {
+	content, err := os.ReadFile(c.fileName)
+	if err != nil {
+		return err
+	}
+
+	modified, err := sjson.Set(string(content), path, value)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.fileName, []byte(modified), 0o600)
+}
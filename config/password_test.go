@@ -0,0 +1,185 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-crypt/crypt/algorithm/pbkdf2"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestMatchPassword(t *testing.T) {
+	pbkdf2Hasher, err := pbkdf2.New(pbkdf2.WithVariantName("sha256"), pbkdf2.WithIterations(pbkdf2.IterationsMin))
+	if err != nil {
+		t.Fatalf("couldn't create hasher: %v", err)
+	}
+
+	pbkdf2Digest, err := pbkdf2Hasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("couldn't hash password: %v", err)
+	}
+
+	hashes := []string{"secret", pbkdf2Digest.Encode()}
+
+	for _, conf := range []*confpar.PasswordHashing{
+		{Algorithm: HashBcrypt, BcryptCost: 10},
+		{Algorithm: HashArgon2id, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1},
+		{Algorithm: HashScrypt, ScryptLN: 4},
+		{Algorithm: HashSHA512Crypt, SHA512CryptRounds: 1000},
+	} {
+		policy, err := getHashPolicy(conf)
+		if err != nil {
+			t.Fatalf("couldn't get policy: %v", err)
+		}
+
+		digest, err := policy.hash("secret")
+		if err != nil {
+			t.Fatalf("couldn't hash password with %s: %v", conf.Algorithm, err)
+		}
+
+		if policy.weaker(digest) {
+			t.Fatalf("hash %s shouldn't be weaker than its own policy", digest)
+		}
+
+		hashes = append(hashes, digest)
+	}
+
+	for _, hash := range hashes {
+		if ok, err := MatchPassword(hash, "secret"); err != nil || !ok {
+			t.Fatalf("password should match %s: %v", hash, err)
+		}
+
+		if ok, _ := MatchPassword(hash, "wrong"); ok {
+			t.Fatalf("wrong password shouldn't match %s", hash)
+		}
+	}
+
+	if _, err := getHashPolicy(&confpar.PasswordHashing{Algorithm: "md5"}); err == nil {
+		t.Fatal("unknown algorithm should be rejected")
+	}
+}
+
+func TestWeakerHash(t *testing.T) {
+	policy, err := getHashPolicy(&confpar.PasswordHashing{Algorithm: HashArgon2id})
+	if err != nil {
+		t.Fatalf("couldn't get policy: %v", err)
+	}
+
+	for hash, weaker := range map[string]bool{
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$a2V5":                   false,
+		"$argon2id$v=19$m=131072,t=4,p=4$c2FsdA$a2V5":                  false,
+		"$argon2id$v=19$m=32768,t=3,p=4$c2FsdA$a2V5":                   true,
+		"$2a$10$jG7tuqIlcUDMl1m1Ytj1TunU7pk.ko8lj3nOGzZvkIeU/BsfPVBra": true,
+	} {
+		if policy.weaker(hash) != weaker {
+			t.Fatalf("weaker(%s) should be %v", hash, weaker)
+		}
+	}
+
+	policy, _ = getHashPolicy(&confpar.PasswordHashing{Algorithm: HashSHA512Crypt, SHA512CryptRounds: 5000})
+	if policy.weaker("$6$salt$key") || !policy.weaker("$6$rounds=1000$salt$key") {
+		t.Fatal("sha512crypt rounds aren't compared properly")
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	weakPolicy, _ := getHashPolicy(&confpar.PasswordHashing{BcryptCost: 10})
+
+	weakHash, err := weakPolicy.hash("secret")
+	if err != nil {
+		t.Fatalf("couldn't hash password: %v", err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "ftpserver.json")
+	content := `{
+		"password_hashing": {"algorithm": "bcrypt", "bcrypt_cost": 11, "rehash_on_login": true},
+		"accesses": [{"user": "alice", "pass": "` + weakHash + `"}]
+	}`
+
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatalf("couldn't write config: %v", err)
+	}
+
+	conf, err := NewConfig(fileName, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load config: %v", err)
+	}
+
+	if _, err := conf.GetAccess("alice", "secret"); err != nil {
+		t.Fatalf("couldn't log in: %v", err)
+	}
+
+	if err := conf.Load(); err != nil {
+		t.Fatalf("couldn't reload config: %v", err)
+	}
+
+	if pass := conf.Content.Accesses[0].Pass; !strings.HasPrefix(pass, "$2b$11$") {
+		t.Fatalf("password should have been re-hashed, got %s", pass)
+	}
+
+	if _, err := conf.GetAccess("alice", "secret"); err != nil {
+		t.Fatalf("couldn't log in with the re-hashed password: %v", err)
+	}
+}
+
+func TestRehashOnConcurrentLogins(t *testing.T) {
+	weakPolicy, _ := getHashPolicy(&confpar.PasswordHashing{BcryptCost: 10})
+
+	weakHash, err := weakPolicy.hash("secret")
+	if err != nil {
+		t.Fatalf("couldn't hash password: %v", err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "ftpserver.json")
+	content := `{
+		"password_hashing": {"algorithm": "bcrypt", "bcrypt_cost": 11, "rehash_on_login": true},
+		"accesses": [{"user": "alice", "pass": "` + weakHash + `"}]
+	}`
+
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatalf("couldn't write config: %v", err)
+	}
+
+	conf, err := NewConfig(fileName, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load config: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 4)
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			access, err := conf.GetAccess("alice", "secret")
+			if err == nil {
+				_, err = conf.ResolveAccess(access, nil)
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("couldn't log in: %v", err)
+		}
+	}
+
+	access, err := conf.GetAccessByUser("alice")
+	if err != nil || !strings.HasPrefix(access.Pass, "$2b$11$") {
+		t.Fatalf("password should have been re-hashed, got %v: %v", access, err)
+	}
+}
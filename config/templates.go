@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/fclairamb/ftpserver/auth/render"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrUnknownTemplate is returned when an access refers to an undefined access template
+var ErrUnknownTemplate = errors.New("unknown access template")
+
+// ResolveAccess returns the final access of a user: the access is merged with its template, if any, and its params
+// are rendered with the access attributes and the given variables.
+func (c *Config) ResolveAccess(access *confpar.Access, vars map[string]string) (*confpar.Access, error) {
+	if access.Template != "" {
+		template := c.Content.AccessTemplates[access.Template]
+		if template == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, access.Template)
+		}
+
+		access = mergeAccess(template, access)
+	}
+
+	allVars := maps.Clone(access.Attributes)
+	if allVars == nil {
+		allVars = make(map[string]string, len(vars))
+	}
+
+	maps.Copy(allVars, vars)
+
+	return render.Access(access, allVars)
+}
+
+// mergeAccess applies the settings of an access on top of its template
+func mergeAccess(template, access *confpar.Access) *confpar.Access {
+	merged := *template
+	merged.User = access.User
+	merged.Pass = access.Pass
+	merged.TOTPSecret = access.TOTPSecret
+	merged.Template = access.Template
+	merged.Attributes = access.Attributes
+	merged.ReadOnly = template.ReadOnly || access.ReadOnly
+	merged.Shared = template.Shared || access.Shared
+	merged.Logging.FtpExchanges = template.Logging.FtpExchanges || access.Logging.FtpExchanges
+	merged.Logging.FileAccesses = template.Logging.FileAccesses || access.Logging.FileAccesses
+
+	if access.Fs != "" {
+		merged.Fs = access.Fs
+	}
+
+	merged.Params = maps.Clone(template.Params)
+	if merged.Params == nil {
+		merged.Params = make(map[string]string, len(access.Params))
+	}
+
+	maps.Copy(merged.Params, access.Params)
+
+	if access.Logging.File != "" {
+		merged.Logging.File = access.Logging.File
+	}
+
+	if access.SyncAndDelete != nil {
+		merged.SyncAndDelete = access.SyncAndDelete
+	}
+
+	if access.MaxSessions != 0 {
+		merged.MaxSessions = access.MaxSessions
+	}
+
+	if access.MaxDownloadRate != 0 {
+		merged.MaxDownloadRate = access.MaxDownloadRate
+	}
+
+	if access.MaxUploadRate != 0 {
+		merged.MaxUploadRate = access.MaxUploadRate
+	}
+
+	if access.Quota != nil {
+		merged.Quota = access.Quota
+	}
+
+	if access.ACL != nil {
+		merged.ACL = access.ACL
+	}
+
+	if access.Mounts != nil {
+		merged.Mounts = access.Mounts
+	}
+
+	if access.ClientCert != nil {
+		merged.ClientCert = access.ClientCert
+	}
+
+	if access.AllowedIPs != nil {
+		merged.AllowedIPs = access.AllowedIPs
+	}
+
+	if access.DeniedIPs != nil {
+		merged.DeniedIPs = access.DeniedIPs
+	}
+
+	return &merged
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestResolveAccess(t *testing.T) {
+	conf, err := FromContent(&confpar.Content{
+		AccessTemplates: map[string]*confpar.Access{
+			"s3-tenant": {
+				Fs:       "s3",
+				ReadOnly: true,
+				Params: map[string]string{
+					"bucket":   "tenants",
+					"basePath": "tenants/{{.User}}",
+					"comment":  "{{.team}} from {{.RemoteIP}}",
+				},
+				Quota: &confpar.Quota{MaxBytes: 1000},
+			},
+		},
+	}, "test.json", slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't create config: %v", err)
+	}
+
+	access, err := conf.ResolveAccess(&confpar.Access{
+		User:       "alice",
+		Pass:       "secret",
+		Template:   "s3-tenant",
+		Attributes: map[string]string{"team": "blue"},
+		Params:     map[string]string{"bucket": "alice-bucket"},
+	}, map[string]string{"User": "alice", "RemoteIP": "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("couldn't resolve access: %v", err)
+	}
+
+	if access.User != "alice" || access.Pass != "secret" || access.Fs != "s3" || !access.ReadOnly {
+		t.Fatalf("unexpected access: %+v", access)
+	}
+
+	if access.Quota == nil || access.Quota.MaxBytes != 1000 {
+		t.Fatalf("the quota should come from the template: %+v", access.Quota)
+	}
+
+	expected := map[string]string{
+		"bucket":   "alice-bucket",
+		"basePath": "tenants/alice",
+		"comment":  "blue from 10.0.0.1",
+	}
+
+	for key, value := range expected {
+		if access.Params[key] != value {
+			t.Fatalf("unexpected %s param: %q instead of %q", key, access.Params[key], value)
+		}
+	}
+
+	if conf.Content.AccessTemplates["s3-tenant"].Params["basePath"] != "tenants/{{.User}}" {
+		t.Fatal("the template was modified")
+	}
+
+	if _, err := conf.ResolveAccess(&confpar.Access{User: "bob", Template: "missing"}, nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Fatalf("expected ErrUnknownTemplate, got: %v", err)
+	}
+
+	// Undefined variables are reported
+	if _, err := conf.ResolveAccess(&confpar.Access{User: "bob", Template: "s3-tenant"}, map[string]string{"User": "bob"}); err == nil {
+		t.Fatal("undefined variables should be reported")
+	}
+}
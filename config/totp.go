@@ -0,0 +1,261 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // RFC 6238 default, supported by all the authenticator apps
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// TOTP settings, matching the defaults of the authenticator apps
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	TOTPSkew   = 1 // Number of periods accepted before and after the current one
+)
+
+// TOTPPendingDuration is the time a two-step login has to send the code after the password
+const TOTPPendingDuration = 2 * time.Minute
+
+// ErrTOTPRequired is returned when the password is right but the TOTP code is missing
+var ErrTOTPRequired = errors.New("password accepted, log in again with the TOTP code as password")
+
+// ErrInvalidTOTPSecret is returned when a TOTP secret isn't valid base32
+var ErrInvalidTOTPSecret = errors.New("invalid TOTP secret")
+
+// totpEncoding is the base32 encoding of the TOTP secrets
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LoginClient identifies the control connection a login comes from. The second step of a two-step TOTP login must
+// come from the same connection as the first one.
+type LoginClient struct {
+	ID         uint32 // Client ID
+	RemoteAddr string // Remote address of the control connection
+}
+
+// pendingLogin is a two-step login waiting for its code
+type pendingLogin struct {
+	user       string
+	remoteAddr string
+	expires    time.Time
+}
+
+// totpTracker prevents the replay of the TOTP codes, and tracks the two-step logins
+type totpTracker struct {
+	sync.Mutex
+	lastCounters map[string]int64         // Counter of the last code accepted, by user
+	pending      map[uint32]*pendingLogin // Two-step logins waiting for a code, by client ID
+	now          func() time.Time
+}
+
+func (t *totpTracker) time() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+
+	return time.Now()
+}
+
+// setPending records that a user sent the right password without a code
+func (t *totpTracker) setPending(client *LoginClient, user string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[uint32]*pendingLogin)
+	}
+
+	t.pending[client.ID] = &pendingLogin{
+		user:       user,
+		remoteAddr: client.RemoteAddr,
+		expires:    t.time().Add(TOTPPendingDuration),
+	}
+}
+
+// takePending tells if a client has a two-step login of a user waiting for a code, and forgets it
+func (t *totpTracker) takePending(client *LoginClient, user string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	pending := t.pending[client.ID]
+	delete(t.pending, client.ID)
+
+	return pending != nil && pending.user == user && pending.remoteAddr == client.RemoteAddr &&
+		pending.expires.After(t.time())
+}
+
+// dropPending forgets the two-step login of a client
+func (t *totpTracker) dropPending(clientID uint32) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.pending, clientID)
+}
+
+// verify checks a code, and that it wasn't already used
+func (t *totpTracker) verify(user, secret, code string) (bool, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+	if err != nil {
+		return false, fmt.Errorf("%w for user %s: %w", ErrInvalidTOTPSecret, user, err)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	current := t.time().Unix() / int64(TOTPPeriod/time.Second)
+
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		if !hmac.Equal([]byte(totpCode(key, counter)), []byte(code)) {
+			continue
+		}
+
+		// A code can't be used twice, nor an older one
+		if counter <= t.lastCounters[user] {
+			return false, nil
+		}
+
+		if t.lastCounters == nil {
+			t.lastCounters = make(map[string]int64)
+		}
+
+		t.lastCounters[user] = counter
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// totpCode computes the code of a counter, as defined by RFC 4226
+func totpCode(key []byte, counter int64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%1000000)
+}
+
+// splitTOTPCode splits a "password+123456" into the password and the code
+func splitTOTPCode(pass string) (string, string, bool) {
+	sep := len(pass) - TOTPDigits - 1
+	if sep < 0 || pass[sep] != '+' || !isTOTPCode(pass[sep+1:]) {
+		return pass, "", false
+	}
+
+	return pass[:sep], pass[sep+1:], true
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkTOTP checks the password and the TOTP code of an access protected by a second factor. The two-step logins
+// require the client they come from.
+func (c *Config) checkTOTP(client *LoginClient, a *confpar.Access, pass string) (bool, error) {
+	password, code, ok := splitTOTPCode(pass)
+
+	// Second step of a two-step login, only the code is sent
+	if !ok && isTOTPCode(pass) && client != nil && c.totp.takePending(client, a.User) {
+		return c.totp.verify(a.User, a.TOTPSecret, pass)
+	}
+
+	match, err := MatchPassword(a.Pass, password)
+	if err != nil || !match {
+		return false, err
+	}
+
+	c.rehashPassword(a, password)
+
+	// First step of a two-step login, only the password is sent
+	if !ok {
+		if client != nil {
+			c.totp.setPending(client, a.User)
+		}
+
+		return false, ErrTOTPRequired
+	}
+
+	return c.totp.verify(a.User, a.TOTPSecret, code)
+}
+
+// DropPendingTOTP forgets the two-step login of a disconnected client
+func (c *Config) DropPendingTOTP(clientID uint32) {
+	c.totp.dropPending(clientID)
+}
+
+// GenerateTOTPSecret generates a random TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// TOTPURI returns the otpauth URI of a secret, that can be imported in authenticator apps
+func TOTPURI(issuer, user, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("digits", fmt.Sprint(TOTPDigits))
+	values.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user,
+		RawQuery: values.Encode(),
+	}).String()
+}
+
+// EnrollTOTP generates the TOTP secret of a user, saves it in the config file and returns its otpauth URI
+func (c *Config) EnrollTOTP(user, issuer string) (string, error) {
+	c.saveSync.Lock()
+	defer c.saveSync.Unlock()
+
+	for i, a := range c.accesses() {
+		if a.User != user {
+			continue
+		}
+
+		secret, err := GenerateTOTPSecret()
+		if err != nil {
+			return "", err
+		}
+
+		if err := c.setInFile(fmt.Sprintf("accesses.%d.totp_secret", i), secret); err != nil {
+			return "", err
+		}
+
+		updated := *a
+		updated.TOTPSecret = secret
+		c.replaceAccess(i, &updated)
+
+		return TOTPURI(issuer, user, secret), nil
+	}
+
+	return "", ErrUnknownUser
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// RFC 6238 test secret: "12345678901234567890"
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(testTOTPSecret)
+	if err != nil {
+		t.Fatalf("couldn't decode secret: %v", err)
+	}
+
+	// RFC 6238 appendix B, truncated to 6 digits
+	for seconds, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+	} {
+		if code := totpCode(key, seconds/30); code != expected {
+			t.Fatalf("code at %d: expected %s, got %s", seconds, expected, code)
+		}
+	}
+}
+
+func newTOTPConfig(t *testing.T, now *time.Time) *Config {
+	t.Helper()
+
+	conf, err := FromContent(&confpar.Content{
+		Accesses: []*confpar.Access{
+			{User: "alice", Pass: "secret", TOTPSecret: testTOTPSecret},
+		},
+	}, "test.json", slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't create config: %v", err)
+	}
+
+	conf.totp.now = func() time.Time { return *now }
+
+	return conf
+}
+
+func TestTOTPLogin(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	conf := newTOTPConfig(t, &now)
+
+	if _, err := conf.GetAccess("alice", "secret+081804"); err != nil {
+		t.Fatalf("couldn't log in with the code: %v", err)
+	}
+
+	// The same code can't be used twice
+	if _, err := conf.GetAccess("alice", "secret+081804"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("a replayed code should be rejected, got %v", err)
+	}
+
+	if _, err := conf.GetAccess("alice", "wrong+081804"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("a wrong password should be rejected, got %v", err)
+	}
+
+	if _, err := conf.GetAccess("alice", "secret+000000"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("a wrong code should be rejected, got %v", err)
+	}
+}
+
+func TestTOTPTwoStepLogin(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	conf := newTOTPConfig(t, &now)
+	client := &LoginClient{ID: 1, RemoteAddr: "10.0.0.1:1234"}
+
+	// Without a pending two-step login, the code alone is refused
+	if _, err := conf.GetClientAccess(client, "alice", "005924"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("a code alone should be rejected, got %v", err)
+	}
+
+	if _, err := conf.GetClientAccess(client, "alice", "secret"); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+
+	if _, err := conf.GetClientAccess(client, "alice", "005924"); err != nil {
+		t.Fatalf("couldn't log in with the code: %v", err)
+	}
+
+	// The pending login expires
+	if _, err := conf.GetClientAccess(client, "alice", "secret"); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+
+	now = now.Add(TOTPPendingDuration + TOTPPeriod*3)
+
+	key, _ := totpEncoding.DecodeString(testTOTPSecret)
+	if _, err := conf.GetClientAccess(client, "alice", totpCode(key, now.Unix()/30)); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("an expired two-step login should be rejected, got %v", err)
+	}
+}
+
+func TestTOTPTwoStepLoginClients(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	conf := newTOTPConfig(t, &now)
+	key, _ := totpEncoding.DecodeString(testTOTPSecret)
+	first := &LoginClient{ID: 1, RemoteAddr: "10.0.0.1:1234"}
+
+	if _, err := conf.GetClientAccess(first, "alice", "secret"); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+
+	// The pending login of a client can't be completed by another one, nor without any client
+	for _, other := range []*LoginClient{{ID: 2, RemoteAddr: "10.6.6.6:4321"}, {ID: 1, RemoteAddr: "10.6.6.6:4321"}, nil} {
+		if _, err := conf.GetClientAccess(other, "alice", "005924"); !errors.Is(err, ErrUnknownUser) {
+			t.Fatalf("the code alone should be rejected for %v, got %v", other, err)
+		}
+	}
+
+	// The pending login is forgotten once the client is disconnected
+	now = now.Add(TOTPPeriod)
+
+	if _, err := conf.GetClientAccess(first, "alice", "secret"); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+
+	conf.DropPendingTOTP(first.ID)
+
+	if _, err := conf.GetClientAccess(first, "alice", totpCode(key, now.Unix()/30)); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("the code of a disconnected client should be rejected, got %v", err)
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "ftpserver.json")
+	if err := os.WriteFile(fileName, []byte(`{"accesses": [{"user": "alice", "pass": "secret"}]}`), 0o600); err != nil {
+		t.Fatalf("couldn't write config: %v", err)
+	}
+
+	conf, err := NewConfig(fileName, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load config: %v", err)
+	}
+
+	uri, err := conf.EnrollTOTP("alice", "ftpserver")
+	if err != nil {
+		t.Fatalf("couldn't enroll user: %v", err)
+	}
+
+	if !strings.HasPrefix(uri, "otpauth://totp/ftpserver:alice?") {
+		t.Fatalf("unexpected URI: %s", uri)
+	}
+
+	if err := conf.Load(); err != nil {
+		t.Fatalf("couldn't reload config: %v", err)
+	}
+
+	if secret := conf.Content.Accesses[0].TOTPSecret; secret == "" || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("secret %q wasn't saved or doesn't match %s", secret, uri)
+	}
+
+	if _, err := conf.EnrollTOTP("bob", "ftpserver"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got %v", err)
+	}
+}
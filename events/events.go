@@ -0,0 +1,186 @@
+// Package events triggers the hooks (webhooks or local commands) defined for server events
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Types of events
+const (
+	UploadComplete   = "upload_complete"
+	DownloadComplete = "download_complete"
+	Delete           = "delete"
+	Rename           = "rename"
+	Mkdir            = "mkdir"
+	Login            = "login"
+	Logout           = "logout"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultRetryDelay = time.Second
+)
+
+// ErrUnexpectedStatus is returned when a webhook doesn't answer with a 2xx status code
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// ErrNoAction is returned when a hook has neither a URL nor a command
+var ErrNoAction = errors.New("hook has no url or command")
+
+// Event describes something that happened during a session
+type Event struct {
+	Type       string    `json:"type"`                  // Type of event
+	Time       time.Time `json:"time"`                  // Time of the event
+	User       string    `json:"user"`                  // User of the session
+	ClientID   uint32    `json:"client_id"`             // Client ID of the session
+	RemoteAddr string    `json:"remote_addr"`           // Remote address of the client
+	Path       string    `json:"path,omitempty"`        // Path of the file or directory
+	TargetPath string    `json:"target_path,omitempty"` // New path of a renamed file
+	Size       int64     `json:"size,omitempty"`        // Bytes transferred
+	Duration   float64   `json:"duration,omitempty"`    // Duration of the transfer, in seconds
+}
+
+// Dispatcher runs the hooks of the events, asynchronously
+type Dispatcher struct {
+	logger  *slog.Logger   // Logger
+	client  *http.Client   // Client used for webhooks
+	pending sync.WaitGroup // Hooks being run
+}
+
+// NewDispatcher creates a dispatcher
+func NewDispatcher(logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+// Fire runs the hooks subscribed to an event, without waiting for them
+func (d *Dispatcher) Fire(hooks []*confpar.EventHook, event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, hook := range hooks {
+		if !subscribed(hook, event.Type) {
+			continue
+		}
+
+		d.pending.Add(1)
+
+		go func(hook *confpar.EventHook) {
+			defer d.pending.Done()
+			d.run(hook, event)
+		}(hook)
+	}
+}
+
+// Wait waits for all the hooks being run to complete
+func (d *Dispatcher) Wait() {
+	d.pending.Wait()
+}
+
+func subscribed(hook *confpar.EventHook, eventType string) bool {
+	for _, on := range hook.On {
+		if on == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// run calls a hook, retrying it with an exponential backoff
+func (d *Dispatcher) run(hook *confpar.EventHook, event *Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Could not encode event", "err", err, "event", event.Type)
+
+		return
+	}
+
+	delay := hook.RetryDelay.Duration
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = d.call(hook, payload)
+		if err == nil {
+			return
+		}
+
+		if attempt >= hook.Retries {
+			break
+		}
+
+		d.logger.Warn("Hook failed, retrying", "err", err, "event", event.Type, "attempt", attempt+1, "delay", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	d.logger.Error("Hook failed", "err", err, "event", event.Type, "url", hook.URL, "command", hook.Command)
+}
+
+func (d *Dispatcher) call(hook *confpar.EventHook, payload []byte) error {
+	timeout := hook.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch {
+	case hook.URL != "":
+		return d.post(ctx, hook, payload)
+	case hook.Command != "":
+		cmd := exec.CommandContext(ctx, hook.Command, hook.Args...) //nolint:gosec
+		cmd.Stdin = bytes.NewReader(payload)
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("command failed: %w: %s", err, output)
+		}
+
+		return nil
+	default:
+		return ErrNoAction
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, hook *confpar.EventHook, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	return nil
+}
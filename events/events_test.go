@@ -0,0 +1,90 @@
+package events
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	var calls atomic.Int32
+
+	received := make(chan *Event, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("missing header: %v", r.Header)
+		}
+
+		event := new(Event)
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			t.Errorf("couldn't decode event: %v", err)
+		}
+
+		received <- event
+	}))
+	defer srv.Close()
+
+	dispatcher := NewDispatcher(slog.Default())
+	dispatcher.Fire([]*confpar.EventHook{
+		{
+			On:         []string{UploadComplete},
+			URL:        srv.URL,
+			Headers:    map[string]string{"Authorization": "Bearer token"},
+			Retries:    2,
+			RetryDelay: confpar.Duration{Duration: 10 * time.Millisecond},
+		},
+		{
+			On:  []string{Delete},
+			URL: srv.URL,
+		},
+	}, &Event{Type: UploadComplete, User: "test", Path: "/file.bin", Size: 42})
+	dispatcher.Wait()
+
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls.Load())
+	}
+
+	event := <-received
+	if event.Type != UploadComplete || event.User != "test" || event.Path != "/file.bin" || event.Size != 42 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "event.json")
+
+	dispatcher := NewDispatcher(slog.Default())
+	dispatcher.Fire([]*confpar.EventHook{
+		{
+			On:      []string{Login},
+			Command: "sh",
+			Args:    []string{"-c", "cat > " + output},
+		},
+	}, &Event{Type: Login, User: "test"})
+	dispatcher.Wait()
+
+	content, err := os.ReadFile(output) //nolint:gosec
+	if err != nil {
+		t.Fatalf("command wasn't run: %v", err)
+	}
+
+	event := new(Event)
+	if err := json.Unmarshal(content, event); err != nil || event.Type != Login || event.User != "test" {
+		t.Fatalf("unexpected event: %s, %v", content, err)
+	}
+}
@@ -0,0 +1,360 @@
+// Package acl provides an afero FS wrapper enforcing path-based access rules
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// Operations that can be allowed or denied
+const (
+	OpDownload = "download" // Reading a file
+	OpUpload   = "upload"   // Creating or writing a file
+	OpList     = "list"     // Listing a directory
+	OpDelete   = "delete"   // Deleting a file or a directory
+	OpRename   = "rename"   // Renaming a file or a directory
+	OpMkdir    = "mkdir"    // Creating a directory
+	OpChmod    = "chmod"    // Changing the mode, owner or times of a file
+)
+
+var operations = map[string]bool{
+	OpDownload: true,
+	OpUpload:   true,
+	OpList:     true,
+	OpDelete:   true,
+	OpRename:   true,
+	OpMkdir:    true,
+	OpChmod:    true,
+}
+
+// ErrDenied is returned when an operation is denied by the rules
+var ErrDenied = fmt.Errorf("denied by access rules: %w", os.ErrPermission)
+
+// ErrUnknownOperation is returned when a rule references an unknown operation
+var ErrUnknownOperation = errors.New("unknown operation")
+
+// rule is a parsed confpar.ACLRule
+type rule struct {
+	pattern []string        // Path pattern elements
+	allow   map[string]bool // Allowed operations
+	deny    map[string]bool // Denied operations
+}
+
+// Fs is a wrapper to check each operation against the access rules
+type Fs struct {
+	src    afero.Fs     // Source file system
+	rules  []*rule      // Rules, in their order of evaluation
+	logger *slog.Logger // Logger for denied operations
+}
+
+// LoadFS creates an instance enforcing the rules. The source is returned as-is if there is no rule.
+func LoadFS(src afero.Fs, rules []*confpar.ACLRule, logger *slog.Logger) (afero.Fs, error) {
+	if len(rules) == 0 {
+		return src, nil
+	}
+
+	fs := &Fs{
+		src:    src,
+		rules:  make([]*rule, 0, len(rules)),
+		logger: logger,
+	}
+
+	for _, r := range rules {
+		parsed := &rule{
+			pattern: splitPath(r.Path),
+			allow:   make(map[string]bool),
+			deny:    make(map[string]bool),
+		}
+
+		for _, list := range []struct {
+			ops []string
+			set map[string]bool
+		}{{r.Allow, parsed.allow}, {r.Deny, parsed.deny}} {
+			for _, op := range list.ops {
+				if !operations[op] {
+					return nil, fmt.Errorf("%w: %s in rule %s", ErrUnknownOperation, op, r.Path)
+				}
+
+				list.set[op] = true
+			}
+		}
+
+		fs.rules = append(fs.rules, parsed)
+	}
+
+	return fs, nil
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, "/")
+}
+
+// match checks if path elements match a pattern, "*" matching within an element and "**" any number of elements
+func match(pattern, elements []string) bool {
+	if len(pattern) == 0 {
+		return len(elements) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elements); i++ {
+			if match(pattern[1:], elements[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(elements) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(pattern[0], elements[0]); err != nil || !ok {
+		return false
+	}
+
+	return match(pattern[1:], elements[1:])
+}
+
+// check returns an error if the first matching rule that mentions the operation denies it
+func (f *Fs) check(op, name string) error {
+	elements := splitPath(name)
+
+	for _, r := range f.rules {
+		if !match(r.pattern, elements) {
+			continue
+		}
+
+		if r.deny[op] {
+			f.logger.Warn("Operation denied by access rules", "op", op, "path", name)
+
+			return &os.PathError{Op: op, Path: name, Err: ErrDenied}
+		}
+
+		if r.allow[op] {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// matchBelow checks if a pattern can match a path located under the given path elements
+func matchBelow(pattern, elements []string) bool {
+	if len(elements) == 0 {
+		return len(pattern) > 0
+	}
+
+	if len(pattern) == 0 {
+		return false
+	}
+
+	if pattern[0] == "**" {
+		return true
+	}
+
+	if ok, err := path.Match(pattern[0], elements[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchBelow(pattern[1:], elements[1:])
+}
+
+// checkTree checks an operation applying to a whole directory tree. It is denied if the path is denied, or if any
+// rule denies it somewhere under the path.
+func (f *Fs) checkTree(op, name string) error {
+	if err := f.check(op, name); err != nil {
+		return err
+	}
+
+	elements := splitPath(name)
+
+	for _, r := range f.rules {
+		if r.deny[op] && matchBelow(r.pattern, elements) {
+			f.logger.Warn("Operation denied by access rules under the path", "op", op, "path", name)
+
+			return &os.PathError{Op: op, Path: name, Err: ErrDenied}
+		}
+	}
+
+	return nil
+}
+
+// readOp returns the operation corresponding to opening a path for reading
+func (f *Fs) readOp(name string) string {
+	if info, err := f.src.Stat(name); err == nil && info.IsDir() {
+		return OpList
+	}
+
+	return OpDownload
+}
+
+// Create checks the upload operation
+func (f *Fs) Create(name string) (afero.File, error) {
+	if err := f.check(OpUpload, name); err != nil {
+		return nil, err
+	}
+
+	return f.src.Create(name)
+}
+
+// Mkdir checks the mkdir operation
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, name); err != nil {
+		return err
+	}
+
+	return f.src.Mkdir(name, perm)
+}
+
+// MkdirAll checks the mkdir operation
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, name); err != nil {
+		return err
+	}
+
+	return f.src.MkdirAll(name, perm)
+}
+
+// Open checks the download or list operation
+func (f *Fs) Open(name string) (afero.File, error) {
+	if err := f.check(f.readOp(name), name); err != nil {
+		return nil, err
+	}
+
+	return f.src.Open(name)
+}
+
+// OpenFile checks the upload operation for writes, and the download or list operation for reads
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	op := OpUpload
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		op = f.readOp(name)
+	}
+
+	if err := f.check(op, name); err != nil {
+		return nil, err
+	}
+
+	return f.src.OpenFile(name, flag, perm)
+}
+
+// Remove checks the delete operation
+func (f *Fs) Remove(name string) error {
+	if err := f.check(OpDelete, name); err != nil {
+		return err
+	}
+
+	return f.src.Remove(name)
+}
+
+// RemoveAll checks the delete operation on the whole tree
+func (f *Fs) RemoveAll(name string) error {
+	if err := f.checkTree(OpDelete, name); err != nil {
+		return err
+	}
+
+	return f.src.RemoveAll(name)
+}
+
+// Rename checks the rename operation on both paths, and on the whole tree being moved. As the moved content leaves
+// its old path, deleting and downloading it must be allowed there, and uploading it must be allowed on the new path.
+func (f *Fs) Rename(oldname, newname string) error {
+	for _, op := range []string{OpRename, OpDelete, OpDownload} {
+		if err := f.checkTree(op, oldname); err != nil {
+			return err
+		}
+	}
+
+	for _, op := range []string{OpRename, OpUpload} {
+		if err := f.check(op, newname); err != nil {
+			return err
+		}
+	}
+
+	return f.src.Rename(oldname, newname)
+}
+
+// Stat is always allowed
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	return f.src.Stat(name)
+}
+
+// SymlinkIfPossible checks the upload operation on the link. As the target can then be accessed through the link,
+// it is denied if any operation is denied on or under the target.
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	if err := f.check(OpUpload, newname); err != nil {
+		return err
+	}
+
+	target := oldname
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(newname), target)
+	}
+
+	for op := range operations {
+		if err := f.checkTree(op, target); err != nil {
+			return err
+		}
+	}
+
+	return utils.Symlink(f.src, oldname, newname)
+}
+
+// ReadlinkIfPossible is always allowed
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.src, name)
+}
+
+// LstatIfPossible is always allowed
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.src, name)
+}
+
+// Name returns the name of the source file system
+func (f *Fs) Name() string {
+	return f.src.Name()
+}
+
+// Chmod checks the chmod operation
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	if err := f.check(OpChmod, name); err != nil {
+		return err
+	}
+
+	return f.src.Chmod(name, mode)
+}
+
+// Chown checks the chmod operation
+func (f *Fs) Chown(name string, uid, gid int) error {
+	if err := f.check(OpChmod, name); err != nil {
+		return err
+	}
+
+	return f.src.Chown(name, uid, gid)
+}
+
+// Chtimes checks the chmod operation
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := f.check(OpChmod, name); err != nil {
+		return err
+	}
+
+	return f.src.Chtimes(name, atime, mtime)
+}
@@ -0,0 +1,166 @@
+package acl
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestMatch(t *testing.T) {
+	for _, item := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/incoming/**", "/incoming", true},
+		{"/incoming/**", "/incoming/a/b.txt", true},
+		{"/incoming/**", "/incomingx/a", false},
+		{"/archive/*.zip", "/archive/2024.zip", true},
+		{"/archive/*.zip", "/archive/sub/2024.zip", false},
+		{"/**/*.tmp", "/a/b/c.tmp", true},
+		{"/", "/", true},
+	} {
+		if have := match(splitPath(item.pattern), splitPath(item.path)); have != item.want {
+			t.Errorf("match(%s, %s): have:%v want:%v", item.pattern, item.path, have, item.want)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	src := afero.NewMemMapFs()
+	if err := afero.WriteFile(src, "/archive/data.csv", []byte("a,b"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if err := src.MkdirAll("/incoming", 0750); err != nil {
+		t.Fatalf("couldn't create dir: %v", err)
+	}
+
+	fs, err := LoadFS(src, []*confpar.ACLRule{
+		{Path: "/incoming/**", Allow: []string{OpUpload, OpMkdir}, Deny: []string{OpDownload, OpDelete}},
+		{Path: "/archive/**", Allow: []string{OpDownload, OpList}, Deny: []string{OpUpload, OpDelete, OpRename}},
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/incoming/drop.txt", []byte("x"), 0600); err != nil {
+		t.Fatalf("upload should be allowed: %v", err)
+	}
+
+	if _, err := fs.Open("/incoming/drop.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("download should be denied, got: %v", err)
+	}
+
+	if err := fs.Remove("/incoming/drop.txt"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("delete should be denied, got: %v", err)
+	}
+
+	if _, err := afero.ReadFile(fs, "/archive/data.csv"); err != nil {
+		t.Fatalf("download should be allowed: %v", err)
+	}
+
+	if _, err := fs.Create("/archive/new.csv"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("upload should be denied, got: %v", err)
+	}
+
+	if err := fs.Rename("/archive/data.csv", "/incoming/data.csv"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("rename should be denied, got: %v", err)
+	}
+
+	// Operations that aren't mentioned by any rule are allowed
+	if err := fs.Mkdir("/other", 0750); err != nil {
+		t.Fatalf("mkdir should be allowed: %v", err)
+	}
+}
+
+func TestDropBoxRename(t *testing.T) {
+	src := afero.NewMemMapFs()
+	if err := afero.WriteFile(src, "/incoming/x", []byte("secret"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	fs, err := LoadFS(src, []*confpar.ACLRule{
+		{Path: "/incoming/**", Allow: []string{OpUpload, OpMkdir}, Deny: []string{OpDownload, OpDelete}},
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	for _, item := range []struct{ oldname, newname string }{
+		{"/incoming/x", "/x"},
+		{"/incoming", "/outgoing"},
+	} {
+		if err := fs.Rename(item.oldname, item.newname); !errors.Is(err, ErrDenied) {
+			t.Fatalf("moving %s to %s should be denied, got: %v", item.oldname, item.newname, err)
+		}
+	}
+
+	if _, err := afero.ReadFile(fs, "/x"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the file shouldn't have been moved, got: %v", err)
+	}
+
+	if err := fs.Remove("/incoming/x"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("delete should be denied, got: %v", err)
+	}
+
+	// Files can still be moved into the drop box
+	if err := afero.WriteFile(fs, "/y", []byte("y"), 0600); err != nil {
+		t.Fatalf("upload should be allowed: %v", err)
+	}
+
+	if err := fs.Rename("/y", "/incoming/y"); err != nil {
+		t.Fatalf("moving into the drop box should be allowed: %v", err)
+	}
+}
+
+func TestParentOperations(t *testing.T) {
+	src := afero.NewMemMapFs()
+	if err := afero.WriteFile(src, "/a/protected/data.csv", []byte("a,b"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if err := afero.WriteFile(src, "/a/other/data.csv", []byte("a,b"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	fs, err := LoadFS(src, []*confpar.ACLRule{
+		{Path: "/a/protected/**", Deny: []string{OpDelete, OpRename}},
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	if err := fs.RemoveAll("/a"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("removing a parent of a protected dir should be denied, got: %v", err)
+	}
+
+	if err := fs.Rename("/a", "/b"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("renaming a parent of a protected dir should be denied, got: %v", err)
+	}
+
+	if _, err := src.Stat("/a/protected/data.csv"); err != nil {
+		t.Fatalf("the protected file should be kept: %v", err)
+	}
+
+	// Trees without protected content can still be removed or renamed
+	if err := fs.Rename("/a/other", "/a/renamed"); err != nil {
+		t.Fatalf("rename should be allowed: %v", err)
+	}
+
+	if err := fs.RemoveAll("/a/renamed"); err != nil {
+		t.Fatalf("remove should be allowed: %v", err)
+	}
+}
+
+func TestUnknownOperation(t *testing.T) {
+	_, err := LoadFS(afero.NewMemMapFs(), []*confpar.ACLRule{{Path: "/**", Allow: []string{"fly"}}}, slog.Default())
+	if !errors.Is(err, ErrUnknownOperation) {
+		t.Fatalf("expected ErrUnknownOperation, got: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package activity provides an afero FS wrapper tracking the transfers of a session
+package activity
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// Transfer directions
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Transfer describes a transfer in progress
+type Transfer struct {
+	Path      string    `json:"path"`      // Path of the file
+	Direction string    `json:"direction"` // Upload or download
+	Start     time.Time `json:"start"`     // Start of the transfer
+	Bytes     int64     `json:"bytes"`     // Bytes transferred so far
+}
+
+// Activity tracks the transfers of a session
+type Activity struct {
+	sync.Mutex
+	downloaded int64     // Bytes downloaded
+	uploaded   int64     // Bytes uploaded
+	transfer   *Transfer // Current transfer, if any
+}
+
+// Fs is a wrapper to track the transfers made on a file system
+type Fs struct {
+	afero.Fs           // Source file system
+	activity *Activity // Tracked activity
+}
+
+// File is a wrapper to track a transfer
+type File struct {
+	afero.File           // Source file
+	activity   *Activity // Tracked activity
+	transfer   *Transfer // Transfer of this file, once it has started
+}
+
+// Stats returns the bytes downloaded and uploaded, and a copy of the current transfer
+func (a *Activity) Stats() (int64, int64, *Transfer) {
+	a.Lock()
+	defer a.Unlock()
+
+	var transfer *Transfer
+
+	if a.transfer != nil {
+		current := *a.transfer
+		transfer = &current
+	}
+
+	return a.downloaded, a.uploaded, transfer
+}
+
+// add accounts for some bytes transferred, starting the transfer if needed
+func (a *Activity) add(f *File, direction string, n int) {
+	a.Lock()
+	defer a.Unlock()
+
+	if f.transfer == nil {
+		f.transfer = &Transfer{Path: f.Name(), Direction: direction, Start: time.Now()}
+	}
+
+	a.transfer = f.transfer
+	a.transfer.Bytes += int64(n)
+
+	if direction == Upload {
+		a.uploaded += int64(n)
+	} else {
+		a.downloaded += int64(n)
+	}
+}
+
+// end ends the transfer of a file
+func (a *Activity) end(f *File) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.transfer != nil && a.transfer == f.transfer {
+		a.transfer = nil
+	}
+}
+
+// LoadFS creates an instance tracking the activity
+func LoadFS(src afero.Fs, activity *Activity) (afero.Fs, error) {
+	return &Fs{
+		Fs:       src,
+		activity: activity,
+	}, nil
+}
+
+func (f *Fs) wrap(src afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{File: src, activity: f.activity}, nil
+}
+
+// Create tracks the created file
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Create(name))
+}
+
+// Open tracks the opened file
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Open(name))
+}
+
+// OpenFile tracks the opened file
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return f.wrap(f.Fs.OpenFile(name, flag, perm))
+}
+
+// SymlinkIfPossible creates a symbolic link on the source file system
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	return utils.Symlink(f.Fs, oldname, newname)
+}
+
+// ReadlinkIfPossible returns the target of a symbolic link on the source file system
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.Fs, name)
+}
+
+// LstatIfPossible returns the info of a file without following symbolic links
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.Fs, name)
+}
+
+// Read tracks the bytes downloaded
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.activity.add(f, Download, n)
+
+	return n, err
+}
+
+// ReadAt tracks the bytes downloaded
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.activity.add(f, Download, n)
+
+	return n, err
+}
+
+// Write tracks the bytes uploaded
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.activity.add(f, Upload, n)
+
+	return n, err
+}
+
+// WriteAt tracks the bytes uploaded
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.activity.add(f, Upload, n)
+
+	return n, err
+}
+
+// WriteString tracks the bytes uploaded
+func (f *File) WriteString(s string) (int, error) {
+	n, err := f.File.WriteString(s)
+	f.activity.add(f, Upload, n)
+
+	return n, err
+}
+
+// Close ends the transfer
+func (f *File) Close() error {
+	f.activity.end(f)
+
+	return f.File.Close()
+}
@@ -0,0 +1,60 @@
+package activity
+
+import (
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestActivity(t *testing.T) {
+	act := &Activity{}
+
+	fs, err := LoadFS(afero.NewMemMapFs(), act)
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	file, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	if _, err := file.Write([]byte("hello world")); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	downloaded, uploaded, transfer := act.Stats()
+	if downloaded != 0 || uploaded != 11 {
+		t.Fatalf("unexpected stats: %d / %d", downloaded, uploaded)
+	}
+
+	if transfer == nil || transfer.Path != "/file.txt" || transfer.Direction != Upload || transfer.Bytes != 11 {
+		t.Fatalf("unexpected transfer: %+v", transfer)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close file: %v", err)
+	}
+
+	if _, _, transfer = act.Stats(); transfer != nil {
+		t.Fatalf("transfer should be over: %+v", transfer)
+	}
+
+	file, err = fs.Open("/file.txt")
+	if err != nil {
+		t.Fatalf("couldn't open file: %v", err)
+	}
+
+	if _, err := io.ReadAll(file); err != nil {
+		t.Fatalf("couldn't read file: %v", err)
+	}
+
+	if downloaded, _, transfer = act.Stats(); downloaded != 11 || transfer == nil || transfer.Direction != Download {
+		t.Fatalf("unexpected download stats: %d, %+v", downloaded, transfer)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close file: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+// Package archive provides a read-only access layer to the content of zip and tar files
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrNoPath is returned when the path parameter is missing
+var ErrNoPath = errors.New("path parameter is required for archive")
+
+// Magic numbers of the supported formats, tar files don't have any at their start
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// entry is a file or a directory of the archive
+type entry struct {
+	info     *fileInfo
+	children []*entry                                  // Entries of a directory, sorted by name
+	open     func(offset int64) (io.ReadCloser, error) // Content of a file from an offset
+}
+
+// Fs exposes the content of an archive as a read-only file system
+type Fs struct {
+	entries map[string]*entry // Entries by clean absolute path
+}
+
+// LoadFs loads a file system from an access description, the archive is read from the source file system
+func LoadFs(access *confpar.Access, source afero.Fs) (afero.Fs, error) {
+	name := access.Params["path"]
+	if name == "" {
+		return nil, ErrNoPath
+	}
+
+	// The archive is only kept open while being indexed, its entries open it again to read their content
+	openArchive := func() (afero.File, error) { return source.Open(name) }
+
+	file, err := openArchive()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(zstdMagic))
+	if _, err := file.ReadAt(magic, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	fs := newFs(info.ModTime())
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, emptyZipMagic):
+		err = fs.loadZip(file, info.Size(), openArchive)
+	case bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic):
+		err = fs.loadCompressedTar(openArchive)
+	default:
+		err = fs.loadTar(file, openArchive)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load archive %s: %w", name, err)
+	}
+
+	for _, e := range fs.entries {
+		sort.Slice(e.children, func(i, j int) bool { return e.children[i].info.name < e.children[j].info.name })
+	}
+
+	return fs, nil
+}
+
+func newFs(modTime time.Time) *Fs {
+	return &Fs{entries: map[string]*entry{
+		"/": {info: &fileInfo{name: "/", modTime: modTime, mode: os.ModeDir | 0o555}},
+	}}
+}
+
+// add indexes an entry of the archive, and the directories containing it
+func (fs *Fs) add(name string, info *fileInfo, open func(int64) (io.ReadCloser, error)) {
+	name = path.Clean("/" + strings.TrimSuffix(name, "/"))
+	if name == "/" {
+		return
+	}
+
+	info.name = path.Base(name)
+
+	if existing := fs.entries[name]; existing != nil {
+		// Directories might have been created for their content first, duplicated files are overridden
+		existing.info, existing.open = info, open
+
+		return
+	}
+
+	fs.entries[name] = &entry{info: info, open: open}
+
+	parent := path.Dir(name)
+	if fs.entries[parent] == nil {
+		fs.add(parent, &fileInfo{modTime: info.modTime, mode: os.ModeDir | 0o555}, nil)
+	}
+
+	fs.entries[parent].children = append(fs.entries[parent].children, fs.entries[name])
+}
+
+// Name of the file system
+func (fs *Fs) Name() string {
+	return "archive"
+}
+
+func (fs *Fs) get(op, name string) (*entry, error) {
+	e := fs.entries[path.Clean("/"+name)]
+	if e == nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+
+	return e, nil
+}
+
+// Open opens a file or a directory of the archive
+func (fs *Fs) Open(name string) (afero.File, error) {
+	e, err := fs.get("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{name: name, entry: e}, nil
+}
+
+// OpenFile opens a file or a directory of the archive, for reading only
+func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+
+	return fs.Open(name)
+}
+
+// Stat returns the info of a file or a directory of the archive
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.get("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.info, nil
+}
+
+// Create is not allowed
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EPERM}
+}
+
+// Mkdir is not allowed
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+// MkdirAll is not allowed
+func (fs *Fs) MkdirAll(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+// Remove is not allowed
+func (fs *Fs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+// RemoveAll is not allowed
+func (fs *Fs) RemoveAll(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+// Rename is not allowed
+func (fs *Fs) Rename(oldname, _ string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, Err: syscall.EPERM}
+}
+
+// Chmod is not allowed
+func (fs *Fs) Chmod(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+// Chown is not allowed
+func (fs *Fs) Chown(name string, _, _ int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+}
+
+// Chtimes is not allowed
+func (fs *Fs) Chtimes(name string, _, _ time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// readCloser reads some content, and closes the archive it comes from when done
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openSection opens the archive to read a part of it
+func openSection(openArchive func() (afero.File, error), offset, size int64) (*readCloser, error) {
+	file, err := openArchive()
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCloser{Reader: io.NewSectionReader(file, offset, size), Closer: file}, nil
+}
+
+// discard skips the beginning of some content
+func discard(reader io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		return reader, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil && !errors.Is(err, io.EOF) {
+		_ = reader.Close()
+
+		return nil, err
+	}
+
+	return reader, nil
+}
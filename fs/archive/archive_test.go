@@ -0,0 +1,312 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/fstest"
+)
+
+const guide = "This is a guide, long enough to be read in several parts."
+
+func buildZip(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer := zip.NewWriter(&buf)
+
+	// The "docs" and "data/2024" directories are only implied by their content
+	for _, file := range []struct {
+		name   string
+		method uint16
+		body   string
+	}{
+		{"readme.txt", zip.Deflate, "hello"},
+		{"docs/guide.txt", zip.Store, guide},
+		{"data/2024/values.csv", zip.Deflate, "1,2,3"},
+		{"empty/", zip.Store, ""},
+	} {
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: file.name, Method: file.method})
+		if err != nil {
+			t.Fatalf("couldn't create %s: %v", file.name, err)
+		}
+
+		_, _ = w.Write([]byte(file.body))
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("couldn't close zip: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, compression string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	var out io.WriteCloser
+
+	switch compression {
+	case "gzip":
+		out = gzip.NewWriter(&buf)
+	case "zstd":
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("couldn't create encoder: %v", err)
+		}
+
+		out = encoder
+	default:
+		out = nopWriteCloser{&buf}
+	}
+
+	writer := tar.NewWriter(out)
+
+	for _, file := range []struct {
+		header *tar.Header
+		body   string
+	}{
+		{&tar.Header{Name: "readme.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5}, "hello"},
+		{&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0o755}, ""},
+		{&tar.Header{Name: "docs/guide.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(guide))}, guide},
+		{&tar.Header{Name: "data/2024/values.csv", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5}, "1,2,3"},
+		{&tar.Header{Name: "empty/", Typeflag: tar.TypeDir, Mode: 0o755}, ""},
+		{&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "docs"}, ""},
+	} {
+		if err := writer.WriteHeader(file.header); err != nil {
+			t.Fatalf("couldn't write header: %v", err)
+		}
+
+		_, _ = writer.Write([]byte(file.body))
+	}
+
+	_ = writer.Close()
+	_ = out.Close()
+
+	return buf.Bytes()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func loadTestFs(t *testing.T, source afero.Fs, name string, content []byte) afero.Fs {
+	t.Helper()
+
+	fstest.WriteFile(t, source, name, content)
+
+	fs, err := LoadFs(&confpar.Access{Fs: "archive", Params: map[string]string{"path": name}}, source)
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs
+}
+
+func TestFormats(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"test.zip":     buildZip(t),
+		"test.tar":     buildTar(t, ""),
+		"test.tar.gz":  buildTar(t, "gzip"),
+		"test.tar.zst": buildTar(t, "zstd"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs := loadTestFs(t, afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()), "/"+name, content)
+
+			fstest.CheckListing(t, fs, "/", "data", "docs", "empty", "readme.txt")
+			fstest.CheckListing(t, fs, "/data", "2024")
+			fstest.CheckListing(t, fs, "/empty")
+			fstest.CheckContent(t, fs, "/readme.txt", "hello")
+			fstest.CheckContent(t, fs, "/docs/guide.txt", guide)
+			fstest.CheckContent(t, fs, "data/2024/values.csv", "1,2,3")
+
+			if info, err := fs.Stat("/docs/guide.txt"); err != nil || info.Size() != int64(len(guide)) || info.IsDir() {
+				t.Fatalf("unexpected stat: %v, %v", info, err)
+			}
+
+			if info, err := fs.Stat("/data"); err != nil || !info.IsDir() {
+				t.Fatalf("unexpected stat of an implied dir: %v, %v", info, err)
+			}
+
+			if _, err := fs.Stat("/link"); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("symbolic links should be skipped: %v", err)
+			}
+
+			file, err := fs.Open("/docs/guide.txt")
+			if err != nil {
+				t.Fatalf("couldn't open: %v", err)
+			}
+
+			defer func() { _ = file.Close() }()
+
+			buf := make([]byte, 5)
+			if n, err := file.ReadAt(buf, 10); err != nil || string(buf[:n]) != guide[10:15] {
+				t.Fatalf("unexpected ReadAt %q: %v", buf[:n], err)
+			}
+
+			if _, err := file.Seek(-10, io.SeekEnd); err != nil {
+				t.Fatalf("couldn't seek: %v", err)
+			}
+
+			if rest, err := io.ReadAll(file); err != nil || string(rest) != guide[len(guide)-10:] {
+				t.Fatalf("unexpected content after seek %q: %v", rest, err)
+			}
+		})
+	}
+}
+
+// openCounter counts the files of a source file system that are still open
+type openCounter struct {
+	afero.Fs
+	open atomic.Int32
+}
+
+func (fs *openCounter) Open(name string) (afero.File, error) {
+	file, err := fs.Fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.open.Add(1)
+
+	return &countedFile{File: file, counter: fs}, nil
+}
+
+type countedFile struct {
+	afero.File
+	counter *openCounter
+}
+
+func (f *countedFile) Close() error {
+	f.counter.open.Add(-1)
+
+	return f.File.Close()
+}
+
+func TestArchiveClosed(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"test.zip":     buildZip(t),
+		"test.tar":     buildTar(t, ""),
+		"test.tar.gz":  buildTar(t, "gzip"),
+		"test.tar.zst": buildTar(t, "zstd"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			source := &openCounter{Fs: afero.NewMemMapFs()}
+			fs := loadTestFs(t, source, "/"+name, content)
+
+			if open := source.open.Load(); open != 0 {
+				t.Fatalf("the archive shouldn't stay open once indexed, %d files open", open)
+			}
+
+			file, err := fs.Open("/docs/guide.txt")
+			if err != nil {
+				t.Fatalf("couldn't open: %v", err)
+			}
+
+			if _, err := file.Read(make([]byte, 5)); err != nil {
+				t.Fatalf("couldn't read: %v", err)
+			}
+
+			if open := source.open.Load(); open != 1 {
+				t.Fatalf("the archive should be open while reading, %d files open", open)
+			}
+
+			if err := file.Close(); err != nil {
+				t.Fatalf("couldn't close: %v", err)
+			}
+
+			fstest.CheckContent(t, fs, "/readme.txt", "hello")
+
+			if open := source.open.Load(); open != 0 {
+				t.Fatalf("the archive should be closed with the files, %d files open", open)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := loadTestFs(t, afero.NewMemMapFs(), "/archives/test.zip", buildZip(t))
+
+	if _, err := fs.OpenFile("/readme.txt", os.O_WRONLY|os.O_TRUNC, 0o644); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on write, got %v", err)
+	}
+
+	if _, err := fs.Create("/new.txt"); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on create, got %v", err)
+	}
+
+	if err := fs.Mkdir("/new", 0o755); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on mkdir, got %v", err)
+	}
+
+	if err := fs.Remove("/readme.txt"); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on remove, got %v", err)
+	}
+
+	if err := fs.Rename("/readme.txt", "/other.txt"); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on rename, got %v", err)
+	}
+
+	file, err := fs.Open("/readme.txt")
+	if err != nil {
+		t.Fatalf("couldn't open: %v", err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	if _, err := file.Write([]byte("hello")); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM on file write, got %v", err)
+	}
+
+	if info, _ := file.Stat(); info.Mode().Perm()&0o222 != 0 {
+		t.Fatalf("files should be read-only: %v", info.Mode())
+	}
+}
+
+func TestLoadFsErrors(t *testing.T) {
+	source := afero.NewMemMapFs()
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{}}, source); !errors.Is(err, ErrNoPath) {
+		t.Fatalf("expected ErrNoPath, got %v", err)
+	}
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"path": "/missing.zip"}}, source); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+
+	if err := afero.WriteFile(source, "/invalid.tar", []byte("not an archive"), 0o644); err != nil {
+		t.Fatalf("couldn't write: %v", err)
+	}
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"path": "/invalid.tar"}}, source); err == nil {
+		t.Fatal("an invalid archive should fail")
+	}
+
+	// Archives can be read from the local file system
+	name := filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(name, buildZip(t), 0o600); err != nil {
+		t.Fatalf("couldn't write: %v", err)
+	}
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"path": name}}, afero.NewOsFs()); err != nil {
+		t.Fatalf("couldn't load from the local file system: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// File is a file or a directory of the archive, opened for reading
+type File struct {
+	name    string
+	entry   *entry
+	offset  int64
+	body    io.ReadCloser // Content from the offset, opened on the first read
+	entries []os.FileInfo // Directory entries not read yet, listed on the first read
+}
+
+// Name returns the name of the file
+func (f *File) Name() string {
+	return f.name
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	if f.body != nil {
+		err := f.body.Close()
+		f.body = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// Read reads the file from the current offset
+func (f *File) Read(p []byte) (int, error) {
+	if f.entry.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+
+	if f.offset >= f.entry.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		body, err := f.entry.open(f.offset)
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads a part of the file
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.entry.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+
+	if off >= f.entry.info.size {
+		return 0, io.EOF
+	}
+
+	body, err := f.entry.open(off)
+	if err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	defer func() { _ = body.Close() }()
+
+	n, err := io.ReadFull(body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Seek moves the read offset, the file is then read again from there
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.info.size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Write is not allowed
+func (f *File) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+// WriteAt is not allowed
+func (f *File) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+// WriteString is not allowed
+func (f *File) WriteString(string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+// Readdir returns the entries of a directory
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.entry.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	if f.entries == nil {
+		f.entries = make([]os.FileInfo, len(f.entry.children))
+		for i, child := range f.entry.children {
+			f.entries[i] = child.info
+		}
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = []os.FileInfo{}
+
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(f.entries))
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+
+	return entries, nil
+}
+
+// Readdirnames returns the names of the entries of a directory
+func (f *File) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+
+	return names, err
+}
+
+// Stat returns the info of the file
+func (f *File) Stat() (os.FileInfo, error) {
+	return f.entry.info, nil
+}
+
+// Sync does nothing, the archive can't be modified
+func (f *File) Sync() error {
+	return nil
+}
+
+// Truncate is not allowed
+func (f *File) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
@@ -0,0 +1,281 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+)
+
+// loadZip indexes the central directory of a zip file
+func (fs *Fs) loadZip(file afero.File, size int64, openArchive func() (afero.File, error)) error {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range reader.File {
+		if zf.FileInfo().IsDir() {
+			fs.add(zf.Name, newFileInfo(zf.FileInfo()), nil)
+
+			continue
+		}
+
+		open, err := zipOpener(zf, openArchive)
+		if err != nil {
+			return fmt.Errorf("invalid zip entry %s: %w", zf.Name, err)
+		}
+
+		fs.add(zf.Name, newFileInfo(zf.FileInfo()), open)
+	}
+
+	return nil
+}
+
+// zipOpener reads the content of a zip entry from its offset in the archive. Stored entries are read directly at the
+// offset, deflated entries are decompressed up to it.
+func zipOpener(zf *zip.File, openArchive func() (afero.File, error)) (func(int64) (io.ReadCloser, error), error) {
+	if zf.Method != zip.Store && zf.Method != zip.Deflate {
+		return nil, zip.ErrAlgorithm
+	}
+
+	dataOffset, err := zf.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(offset int64) (io.ReadCloser, error) {
+		if zf.Method == zip.Store {
+			size := int64(zf.UncompressedSize64) //nolint:gosec // sizes fit
+
+			return openSection(openArchive, dataOffset+offset, size-offset)
+		}
+
+		reader, err := openSection(openArchive, dataOffset, int64(zf.CompressedSize64)) //nolint:gosec // sizes fit
+		if err != nil {
+			return nil, err
+		}
+
+		reader.Reader = &checksumReader{reader: flate.NewReader(reader.Reader), expected: zf.CRC32}
+
+		return discard(reader, offset)
+	}, nil
+}
+
+// checksumReader checks the CRC-32 of a decompressed zip entry once it has been read whole
+type checksumReader struct {
+	reader   io.Reader
+	hash     uint32
+	expected uint32
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash = crc32.Update(r.hash, crc32.IEEETable, p[:n])
+
+	if errors.Is(err, io.EOF) && r.expected != 0 && r.hash != r.expected {
+		err = zip.ErrChecksum
+	}
+
+	return n, err
+}
+
+// countingReader tells the offset of the content of the entries of an uncompressed tar file
+type countingReader struct {
+	file   afero.File
+	offset int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+// Seek lets the tar reader skip the content of the entries instead of reading it
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.file.Seek(offset, whence)
+	if err == nil {
+		r.offset = pos
+	}
+
+	return pos, err
+}
+
+// loadTar indexes an uncompressed tar file, whose entries are then read directly at their offset
+func (fs *Fs) loadTar(file afero.File, openArchive func() (afero.File, error)) error {
+	counter := &countingReader{file: file}
+	reader := tar.NewReader(counter)
+
+	return readTar(reader, func(header *tar.Header) {
+		dataOffset := counter.offset
+
+		fs.add(header.Name, newFileInfo(header.FileInfo()), func(offset int64) (io.ReadCloser, error) {
+			return openSection(openArchive, dataOffset+offset, header.Size-offset)
+		})
+	})
+}
+
+// loadCompressedTar indexes a gzip or zstd compressed tar file. As it can't be read at random positions, the entries
+// are read by decompressing the archive up to them.
+func (fs *Fs) loadCompressedTar(openArchive func() (afero.File, error)) error {
+	reader, err := openTar(openArchive)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	index := 0
+
+	return readTar(reader.Reader, func(header *tar.Header) {
+		position := index
+		index++
+
+		fs.add(header.Name, newFileInfo(header.FileInfo()), func(offset int64) (io.ReadCloser, error) {
+			reader, err := openTar(openArchive)
+			if err != nil {
+				return nil, err
+			}
+
+			for range position + 1 {
+				if _, err := reader.Next(); err != nil {
+					_ = reader.Close()
+
+					return nil, fmt.Errorf("could not find %s in the archive: %w", header.Name, err)
+				}
+			}
+
+			return discard(reader, offset)
+		})
+	})
+}
+
+// readTar calls found for each directory and regular file of a tar file, the other types are skipped
+func readTar(reader *tar.Reader, found func(header *tar.Header)) error {
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid tar file: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			found(header)
+		case tar.TypeReg:
+			found(header)
+		}
+	}
+}
+
+// tarReader reads a compressed tar file, and closes it when done
+type tarReader struct {
+	*tar.Reader
+	closers []io.Closer
+}
+
+func (r *tarReader) Read(p []byte) (int, error) {
+	return r.Reader.Read(p)
+}
+
+func (r *tarReader) Close() error {
+	var err error
+
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if errClose := r.closers[i].Close(); err == nil {
+			err = errClose
+		}
+	}
+
+	return err
+}
+
+// closerFunc adapts the zstd decoder, whose Close doesn't return an error
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+
+	return nil
+}
+
+// openTar opens a gzip or zstd compressed tar file
+func openTar(openArchive func() (afero.File, error)) (*tarReader, error) {
+	file, err := openArchive()
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+	result := &tarReader{closers: []io.Closer{file}}
+
+	var decompressed io.Reader
+
+	magic, _ := buffered.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			_ = result.Close()
+
+			return nil, err
+		}
+
+		result.closers = append(result.closers, gzipReader)
+		decompressed = gzipReader
+	default:
+		zstdReader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			_ = result.Close()
+
+			return nil, err
+		}
+
+		result.closers = append(result.closers, closerFunc(zstdReader.Close))
+		decompressed = zstdReader
+	}
+
+	result.Reader = tar.NewReader(decompressed)
+
+	return result, nil
+}
+
+// fileInfo describes a file or a directory of the archive
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+func newFileInfo(info os.FileInfo) *fileInfo {
+	mode := info.Mode().Perm() &^ 0o222 // Read-only
+
+	if info.IsDir() {
+		mode |= os.ModeDir
+	}
+
+	return &fileInfo{size: info.Size(), modTime: info.ModTime(), mode: mode}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) Sys() any           { return nil }
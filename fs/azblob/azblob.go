@@ -0,0 +1,482 @@
+// Package azblob provides an Azure Blob Storage access layer
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// DefaultBlockSize is the size of the blocks uploaded at once
+const DefaultBlockSize = 4 * 1024 * 1024
+
+// ErrNoContainer is returned when the container parameter is missing
+var ErrNoContainer = errors.New("container parameter is required for azblob")
+
+// ErrNoCredentials is returned when no authentication method is configured
+var ErrNoCredentials = errors.New(
+	"azblob requires a connection_string, a sas_token, or an account_name and account_key")
+
+// ErrNotSupported is returned for the operations blobs can't do, like appending or random writes
+var ErrNotSupported = errors.New("not supported by azblob")
+
+// Fs is an Azure Blob Storage container exposed as a file system. Directories are emulated on the
+// "/"-delimited blob names, and created as empty "dir/" marker blobs.
+type Fs struct {
+	client    *container.Client
+	basePath  string // Prefix of the blob names, without leading or trailing slash
+	blockSize int64  // Size of the uploaded blocks
+}
+
+// LoadFs loads a file system from an access description
+func LoadFs(access *confpar.Access) (afero.Fs, error) {
+	par := access.Params
+
+	containerName := par["container"]
+	if containerName == "" {
+		return nil, ErrNoContainer
+	}
+
+	client, err := newClient(par, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(DefaultBlockSize)
+	if value := par["block_size"]; value != "" {
+		blockSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block_size %q: %w", value, err)
+		}
+	}
+
+	return NewFs(client, par["basePath"], blockSize), nil
+}
+
+// newClient creates the container client, from a connection string, a SAS token or a shared key
+func newClient(par map[string]string, containerName string) (*container.Client, error) {
+	if connectionString := par["connection_string"]; connectionString != "" {
+		return container.NewClientFromConnectionString(connectionString, containerName, nil)
+	}
+
+	accountName := par["account_name"]
+
+	endpoint := par["endpoint"]
+	if endpoint == "" && accountName != "" {
+		endpoint = "https://" + accountName + ".blob.core.windows.net"
+	}
+
+	containerURL := strings.TrimSuffix(endpoint, "/") + "/" + containerName
+
+	if sasToken := par["sas_token"]; sasToken != "" {
+		return container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(sasToken, "?"), nil)
+	}
+
+	if accountName == "" || par["account_key"] == "" {
+		return nil, ErrNoCredentials
+	}
+
+	cred, err := container.NewSharedKeyCredential(accountName, par["account_key"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid azblob account key: %w", err)
+	}
+
+	return container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+}
+
+// NewFs creates a file system on a container
+func NewFs(client *container.Client, basePath string, blockSize int64) *Fs {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	return &Fs{
+		client:    client,
+		basePath:  strings.Trim(basePath, "/"),
+		blockSize: blockSize,
+	}
+}
+
+// Name of the file system
+func (fs *Fs) Name() string {
+	return "azblob"
+}
+
+// key returns the blob name of a path, empty for the root
+func (fs *Fs) key(name string) string {
+	return strings.TrimPrefix(path.Join(fs.basePath, path.Clean("/"+name)), "/")
+}
+
+// dirPrefix returns the prefix of the blobs of a directory
+func (fs *Fs) dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	return key + "/"
+}
+
+// Create creates a file
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// Mkdir creates a directory, as an empty marker blob
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	if _, err := fs.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	key := fs.key(name)
+	if key == "" {
+		return nil
+	}
+
+	_, err := fs.client.NewBlockBlobClient(key+"/").UploadBuffer(context.Background(), nil, nil)
+
+	return pathError("mkdir", name, err)
+}
+
+// MkdirAll creates a directory and all its missing parents
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	current := "/"
+
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		current = path.Join(current, part)
+
+		info, err := fs.Stat(current)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+
+			continue
+		}
+
+		if err := fs.Mkdir(current, perm); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open opens a file or a directory for reading
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file for reading, or creates it for writing
+func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &File{fs: fs, name: name, info: info}, nil
+	}
+
+	if flag&(os.O_APPEND|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+
+	if info, err := fs.Stat(name); err == nil && info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	return fs.newWriter(name), nil
+}
+
+// newWriter starts the streaming upload of a file
+func (fs *Fs) newWriter(name string) *File {
+	reader, writer := io.Pipe()
+	file := &File{fs: fs, name: name, writer: writer, uploaded: make(chan error, 1)}
+
+	go func() {
+		_, err := fs.client.NewBlockBlobClient(fs.key(name)).UploadStream(
+			context.Background(),
+			reader,
+			&blockblob.UploadStreamOptions{BlockSize: fs.blockSize},
+		)
+
+		// Unblocks the writes when the upload failed
+		_ = reader.CloseWithError(err)
+		file.uploaded <- err
+	}()
+
+	return file
+}
+
+// Remove removes a file or an empty directory
+func (fs *Fs) Remove(name string) error {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	key := fs.key(name)
+
+	if !info.IsDir() {
+		_, err = fs.client.NewBlobClient(key).Delete(context.Background(), nil)
+
+		return pathError("remove", name, err)
+	}
+
+	names, err := fs.list(fs.dirPrefix(key), 2) //nolint:mnd // The marker and any other blob
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	for _, blobName := range names {
+		if blobName != fs.dirPrefix(key) {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	return fs.deleteBlobs("remove", name, names)
+}
+
+// RemoveAll removes a file or a directory and all its content
+func (fs *Fs) RemoveAll(name string) error {
+	key := fs.key(name)
+
+	names, err := fs.list(fs.dirPrefix(key), 0)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	if key != "" {
+		names = append(names, key)
+	}
+
+	return fs.deleteBlobs("remove", name, names)
+}
+
+// deleteBlobs deletes some blobs, ignoring the ones that don't exist
+func (fs *Fs) deleteBlobs(op, name string, names []string) error {
+	for _, blobName := range names {
+		_, err := fs.client.NewBlobClient(blobName).Delete(context.Background(), nil)
+		if err != nil && !isNotFound(err) {
+			return pathError(op, name, err)
+		}
+	}
+
+	return nil
+}
+
+// Rename moves a file or a directory, by copying and deleting its blobs
+func (fs *Fs) Rename(oldname, newname string) error {
+	info, err := fs.Stat(oldname)
+	if err != nil {
+		return err
+	}
+
+	oldKey, newKey := fs.key(oldname), fs.key(newname)
+
+	if !info.IsDir() {
+		if err := fs.copyBlob(oldKey, newKey); err != nil {
+			return pathError("rename", oldname, err)
+		}
+
+		return fs.deleteBlobs("rename", oldname, []string{oldKey})
+	}
+
+	names, err := fs.list(fs.dirPrefix(oldKey), 0)
+	if err != nil {
+		return pathError("rename", oldname, err)
+	}
+
+	for _, blobName := range names {
+		target := fs.dirPrefix(newKey) + strings.TrimPrefix(blobName, fs.dirPrefix(oldKey))
+		if err := fs.copyBlob(blobName, target); err != nil {
+			return pathError("rename", oldname, err)
+		}
+	}
+
+	return fs.deleteBlobs("rename", oldname, names)
+}
+
+// copyBlob copies a blob by streaming its content, which works with all the authentication methods
+func (fs *Fs) copyBlob(source, target string) error {
+	ctx := context.Background()
+
+	resp, err := fs.client.NewBlobClient(source).DownloadStream(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	_, err = fs.client.NewBlockBlobClient(target).UploadStream(
+		ctx,
+		resp.Body,
+		&blockblob.UploadStreamOptions{BlockSize: fs.blockSize},
+	)
+
+	return err
+}
+
+// Stat returns the info of a file, or of a directory when blobs exist under its prefix
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	key := fs.key(name)
+	if key == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	props, err := fs.client.NewBlobClient(key).GetProperties(context.Background(), nil)
+	if err == nil {
+		info := &fileInfo{name: path.Base(key), size: deref(props.ContentLength)}
+		if props.LastModified != nil {
+			info.modTime = *props.LastModified
+		}
+
+		return info, nil
+	}
+
+	if !isNotFound(err) {
+		return nil, pathError("stat", name, err)
+	}
+
+	names, err := fs.list(fs.dirPrefix(key), 1)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return &fileInfo{name: path.Base(key), dir: true}, nil
+}
+
+// list returns the names of the blobs under a prefix, up to max when it's not zero
+func (fs *Fs) list(prefix string, maxResults int) ([]string, error) {
+	opts := &container.ListBlobsFlatOptions{Prefix: &prefix}
+	if maxResults > 0 {
+		opts.MaxResults = to.Ptr(int32(maxResults)) //nolint:gosec // Small value
+	}
+
+	var names []string
+
+	pager := fs.client.NewListBlobsFlatPager(opts)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			names = append(names, deref(item.Name))
+		}
+
+		if maxResults > 0 && len(names) >= maxResults {
+			return names[:maxResults], nil
+		}
+	}
+
+	return names, nil
+}
+
+// readDir returns the files and directories directly under a directory
+func (fs *Fs) readDir(name string) ([]os.FileInfo, error) {
+	prefix := fs.dirPrefix(fs.key(name))
+
+	var infos []os.FileInfo
+
+	pager := fs.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, pathError("readdir", name, err)
+		}
+
+		for _, dir := range page.Segment.BlobPrefixes {
+			infos = append(infos, &fileInfo{
+				name: strings.TrimSuffix(strings.TrimPrefix(deref(dir.Name), prefix), "/"),
+				dir:  true,
+			})
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			// The directory marker isn't part of its content
+			if deref(item.Name) == prefix {
+				continue
+			}
+
+			info := &fileInfo{name: strings.TrimPrefix(deref(item.Name), prefix)}
+			if item.Properties != nil {
+				info.size = deref(item.Properties.ContentLength)
+				info.modTime = deref(item.Properties.LastModified)
+			}
+
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// Chmod is not supported by blobs, and ignored
+func (fs *Fs) Chmod(string, os.FileMode) error {
+	return nil
+}
+
+// Chown is not supported by blobs, and ignored
+func (fs *Fs) Chown(string, int, int) error {
+	return nil
+}
+
+// Chtimes is not supported by blobs, and ignored
+func (fs *Fs) Chtimes(string, time.Time, time.Time) error {
+	return nil
+}
+
+// isNotFound tells if an error is a missing blob or container
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// pathError converts the not found errors, and adds the operation and path to the other ones
+func pathError(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isNotFound(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	default:
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+}
+
+// deref returns the value of an optional field of the SDK, or its zero value
+func deref[T any](p *T) T {
+	var value T
+	if p != nil {
+		value = *p
+	}
+
+	return value
+}
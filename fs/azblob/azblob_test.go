@@ -0,0 +1,350 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/fstest"
+)
+
+// Well-known Azurite development account
+const (
+	testAccount = "devstoreaccount1"
+	testKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+type testBlob struct {
+	content  []byte
+	modified time.Time
+}
+
+// testServer is an Azurite-style stand-in, implementing the blob operations used by the file system
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	blobs    map[string]*testBlob
+	staged   map[string][]byte // Staged blocks, by blob name and block ID
+	commits  []int             // Number of blocks of each committed block list
+	requests []*http.Request
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	srv := &testServer{blobs: make(map[string]*testBlob), staged: make(map[string][]byte)}
+	srv.Server = httptest.NewServer(srv)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func (s *testServer) endpoint() string {
+	return s.URL + "/" + testAccount
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r)
+
+	// /account/container/blob
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != testAccount {
+		http.Error(w, "bad path", http.StatusBadRequest)
+
+		return
+	}
+
+	query := r.URL.Query()
+
+	if len(parts) == 2 {
+		if r.Method == http.MethodGet && query.Get("comp") == "list" {
+			s.list(w, query)
+
+			return
+		}
+
+		http.Error(w, "unsupported", http.StatusBadRequest)
+
+		return
+	}
+
+	name := parts[2]
+
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r, name)
+	case http.MethodHead, http.MethodGet:
+		s.get(w, r, name)
+	case http.MethodDelete:
+		if _, ok := s.blobs[name]; !ok {
+			notFound(w)
+
+			return
+		}
+
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func notFound(w http.ResponseWriter) {
+	w.Header().Set("x-ms-error-code", "BlobNotFound")
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (s *testServer) put(w http.ResponseWriter, r *http.Request, name string) {
+	body, _ := io.ReadAll(r.Body)
+
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		s.staged[name+"/"+r.URL.Query().Get("blockid")] = body
+	case "blocklist":
+		var list struct {
+			Blocks []string `xml:",any"`
+		}
+
+		if err := xml.Unmarshal(body, &list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		var content []byte
+		for _, id := range list.Blocks {
+			content = append(content, s.staged[name+"/"+id]...)
+			delete(s.staged, name+"/"+id)
+		}
+
+		s.blobs[name] = &testBlob{content: content, modified: time.Now()}
+		s.commits = append(s.commits, len(list.Blocks))
+	default:
+		s.blobs[name] = &testBlob{content: body, modified: time.Now()}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *testServer) get(w http.ResponseWriter, r *http.Request, name string) {
+	blob, ok := s.blobs[name]
+	if !ok {
+		notFound(w)
+
+		return
+	}
+
+	w.Header().Set("Last-Modified", blob.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.Header().Set("ETag", `"0x1"`)
+
+	content := blob.content
+	status := http.StatusOK
+
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		var start, end int
+
+		bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+		start, _ = strconv.Atoi(bounds[0])
+
+		end = len(content) - 1
+		if len(bounds) == 2 && bounds[1] != "" {
+			end, _ = strconv.Atoi(bounds[1])
+			end = min(end, len(content)-1)
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		content = content[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(content)
+	}
+}
+
+func (s *testServer) list(w http.ResponseWriter, query map[string][]string) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+
+		return ""
+	}
+
+	prefix, delimiter, marker := get("prefix"), get("delimiter"), get("marker")
+	maxResults, _ := strconv.Atoi(get("maxresults"))
+
+	names := make([]string, 0, len(s.blobs))
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var (
+		out        bytes.Buffer
+		count      int
+		nextMarker string
+		prefixes   = make(map[string]bool)
+	)
+
+	out.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name < marker {
+			continue
+		}
+
+		if maxResults > 0 && count == maxResults {
+			nextMarker = name
+
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				dir := name[:len(prefix)+i+1]
+				if !prefixes[dir] {
+					prefixes[dir] = true
+					count++
+					_, _ = fmt.Fprintf(&out, "<BlobPrefix><Name>%s</Name></BlobPrefix>", dir)
+				}
+
+				continue
+			}
+		}
+
+		count++
+		_, _ = fmt.Fprintf(&out,
+			"<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified>"+
+				"<Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>",
+			name, s.blobs[name].modified.UTC().Format(http.TimeFormat), len(s.blobs[name].content))
+	}
+
+	_, _ = fmt.Fprintf(&out, "</Blobs><NextMarker>%s</NextMarker></EnumerationResults>", nextMarker)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(out.Bytes())
+}
+
+func (s *testServer) blobNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.blobs))
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// loadFs loads a file system on the container "data" of the server, with the shared key of the test account
+func (s *testServer) loadFs(t *testing.T, params map[string]string) afero.Fs {
+	t.Helper()
+
+	params["container"] = "data"
+	params["endpoint"] = s.endpoint()
+
+	if params["sas_token"] == "" {
+		params["account_name"] = testAccount
+		params["account_key"] = testKey
+	}
+
+	fs, err := LoadFs(&confpar.Access{Fs: "azblob", Params: params})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs
+}
+
+func TestBlockUpload(t *testing.T) {
+	srv := newTestServer(t)
+	fs := srv.loadFs(t, map[string]string{
+		"block_size": strconv.Itoa(1024 * 1024),
+		"basePath":   "/users/alice/",
+	})
+
+	content := bytes.Repeat([]byte("0123456789"), 250*1024)
+	fstest.WriteFile(t, fs, "/big.bin", content)
+
+	if names := srv.blobNames(); len(names) != 1 || names[0] != "users/alice/big.bin" {
+		t.Fatalf("unexpected blobs: %v", names)
+	}
+
+	if len(srv.commits) != 1 || srv.commits[0] != 3 {
+		t.Fatalf("expected a single commit of 3 blocks, got %v", srv.commits)
+	}
+
+	if auth := srv.requests[0].Header.Get("Authorization"); !strings.HasPrefix(auth, "SharedKey "+testAccount+":") {
+		t.Fatalf("requests should be signed with the shared key, got %q", auth)
+	}
+
+	// Downloads use ranged reads
+	fstest.CheckRandomAccess(t, fs, "/big.bin", content)
+}
+
+func TestDirectories(t *testing.T) {
+	srv := newTestServer(t)
+	fs := srv.loadFs(t, map[string]string{})
+
+	fstest.CheckDirectories(t, fs)
+
+	// Directory only existing through the name of its blobs
+	fstest.WriteFile(t, fs, "/virtual/file.txt", []byte("virtual"))
+
+	if info, err := fs.Stat("/virtual"); err != nil || !info.IsDir() {
+		t.Fatalf("unexpected stat of /virtual: %v, %v", info, err)
+	}
+
+	fstest.CheckListing(t, fs, "/", "virtual")
+
+	if names := srv.blobNames(); len(names) != 1 || names[0] != "virtual/file.txt" {
+		t.Fatalf("unexpected remaining blobs: %v", names)
+	}
+}
+
+func TestSASToken(t *testing.T) {
+	srv := newTestServer(t)
+	fs := srv.loadFs(t, map[string]string{"sas_token": "?sv=2023-01-03&sig=signature"})
+
+	fstest.WriteFile(t, fs, "/file.txt", []byte("hello"))
+
+	request := srv.requests[0]
+	if request.URL.Query().Get("sig") != "signature" || request.Header.Get("Authorization") != "" {
+		t.Fatalf("requests should be authorized by the SAS token: %s", request.URL)
+	}
+}
+
+func TestLoadFsErrors(t *testing.T) {
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{}}); !errors.Is(err, ErrNoContainer) {
+		t.Fatalf("expected ErrNoContainer, got %v", err)
+	}
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"container": "data"}}); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("expected ErrNoCredentials, got %v", err)
+	}
+}
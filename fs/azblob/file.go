@@ -0,0 +1,250 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// File is a blob being read or written, or a directory
+type File struct {
+	fs   *Fs
+	name string
+
+	// Reading
+	info    os.FileInfo
+	offset  int64
+	body    io.ReadCloser // Content from the offset, opened on the first read
+	entries []os.FileInfo // Directory entries not read yet, listed on the first read
+
+	// Writing
+	writer   *io.PipeWriter
+	uploaded chan error
+	size     int64
+}
+
+// Name returns the name of the file
+func (f *File) Name() string {
+	return f.name
+}
+
+// Close closes the file, and completes its upload
+func (f *File) Close() error {
+	if f.writer != nil {
+		_ = f.writer.Close()
+		f.writer = nil
+
+		return pathError("close", f.name, <-f.uploaded)
+	}
+
+	if f.body != nil {
+		err := f.body.Close()
+		f.body = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// Read reads the blob from the current offset
+func (f *File) Read(p []byte) (int, error) {
+	if f.writer != nil || f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrNotSupported}
+	}
+
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		resp, err := f.fs.client.NewBlobClient(f.fs.key(f.name)).DownloadStream(context.Background(), &blob.DownloadStreamOptions{
+			Range: blob.HTTPRange{Offset: f.offset},
+		})
+		if err != nil {
+			return 0, pathError("read", f.name, err)
+		}
+
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads a part of the blob
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.writer != nil || f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrNotSupported}
+	}
+
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	resp, err := f.fs.client.NewBlobClient(f.fs.key(f.name)).DownloadStream(context.Background(), &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: off, Count: int64(len(p))},
+	})
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Seek moves the read offset, the blob is then read again from there
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.writer != nil {
+		// Uploads can only be sequential
+		if offset == f.size && whence == io.SeekStart || offset == 0 && whence == io.SeekCurrent {
+			return f.size, nil
+		}
+
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrNotSupported}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Write streams some content to the blob
+func (f *File) Write(p []byte) (int, error) {
+	if f.writer == nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	n, err := f.writer.Write(p)
+	f.size += int64(n)
+
+	if err != nil {
+		return n, pathError("write", f.name, err)
+	}
+
+	return n, nil
+}
+
+// WriteAt is not supported, blobs are written sequentially
+func (f *File) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: ErrNotSupported}
+}
+
+// WriteString writes a string to the blob
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Readdir returns the entries of a directory
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if f.info == nil || !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if f.entries == nil {
+		entries, err := f.fs.readDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		f.entries = entries
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = []os.FileInfo{}
+
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(f.entries))
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+
+	return entries, nil
+}
+
+// Readdirnames returns the names of the entries of a directory
+func (f *File) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+
+	return names, err
+}
+
+// Stat returns the info of the file
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.info != nil {
+		return f.info, nil
+	}
+
+	return &fileInfo{name: path.Base(f.name), size: f.size, modTime: time.Now()}, nil
+}
+
+// Sync does nothing, the blob is committed on close
+func (f *File) Sync() error {
+	return nil
+}
+
+// Truncate is not supported
+func (f *File) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: ErrNotSupported}
+}
+
+// fileInfo describes a blob or a virtual directory
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+
+	return 0o644
+}
@@ -4,20 +4,27 @@ package fs
 import (
 	"fmt"
 	"log/slog"
+	"strings"
 
 	snd "github.com/fclairamb/afero-snd"
 	"github.com/spf13/afero"
 
 	"github.com/fclairamb/ftpserver/config/confpar"
 	"github.com/fclairamb/ftpserver/fs/afos"
+	"github.com/fclairamb/ftpserver/fs/archive"
+	"github.com/fclairamb/ftpserver/fs/azblob"
 	"github.com/fclairamb/ftpserver/fs/dropbox"
+	"github.com/fclairamb/ftpserver/fs/ftp"
 	"github.com/fclairamb/ftpserver/fs/gcs"
 	"github.com/fclairamb/ftpserver/fs/gdrive"
 	"github.com/fclairamb/ftpserver/fs/keycloak"
 	"github.com/fclairamb/ftpserver/fs/mail"
+	"github.com/fclairamb/ftpserver/fs/memory"
+	"github.com/fclairamb/ftpserver/fs/mount"
 	"github.com/fclairamb/ftpserver/fs/s3"
 	"github.com/fclairamb/ftpserver/fs/sftp"
 	"github.com/fclairamb/ftpserver/fs/telegram"
+	"github.com/fclairamb/ftpserver/fs/webdav"
 )
 
 // UnsupportedFsError is returned when the described file system is not supported
@@ -37,12 +44,20 @@ func LoadFs(access *confpar.Access, logger *slog.Logger) (afero.Fs, error) {
 	switch access.Fs {
 	case "os":
 		fs, err = afos.LoadFs(access)
+	case "memory":
+		fs, err = memory.LoadFs(access)
 	case "s3":
 		fs, err = s3.LoadFs(access)
 	case "gcs":
 		fs, err = gcs.LoadFs(access)
+	case "azblob":
+		fs, err = azblob.LoadFs(access)
+	case "webdav":
+		fs, err = webdav.LoadFs(access)
 	case "sftp":
 		fs, err = sftp.LoadFs(access, logger.With("component", "sftp"))
+	case "ftp":
+		fs, err = ftp.LoadFs(access)
 	case "mail":
 		fs, err = mail.LoadFs(access)
 	case "gdrive":
@@ -53,10 +68,21 @@ func LoadFs(access *confpar.Access, logger *slog.Logger) (afero.Fs, error) {
 		fs, err = dropbox.LoadFs(access)
 	case "telegram":
 		fs, err = telegram.LoadFs(access, logger.With("component", "telegram"))
+	case "archive":
+		fs, err = loadArchive(access, logger)
+	case "":
+		// An access can be only made of mounts
+		if len(access.Mounts) == 0 {
+			err = &UnsupportedFsError{Type: access.Fs}
+		}
 	default:
 		fs, err = nil, &UnsupportedFsError{Type: access.Fs}
 	}
 
+	if err == nil && len(access.Mounts) > 0 {
+		fs, err = loadMounts(fs, access, logger)
+	}
+
 	if err == nil && access.ReadOnly {
 		fs = afero.NewReadOnlyFs(fs)
 	}
@@ -78,3 +104,58 @@ func LoadFs(access *confpar.Access, logger *slog.Logger) (afero.Fs, error) {
 
 	return fs, err
 }
+
+// loadMounts creates a mount table from the access mounts, on top of the access file system
+func loadMounts(root afero.Fs, access *confpar.Access, logger *slog.Logger) (afero.Fs, error) {
+	mounts := make(map[string]afero.Fs, len(access.Mounts))
+
+	for _, m := range access.Mounts {
+		if _, ok := mounts[m.Path]; ok {
+			return nil, fmt.Errorf("%w: %s", mount.ErrDuplicateMountPath, m.Path)
+		}
+
+		mountFs, err := LoadFs(&confpar.Access{
+			User:     access.User,
+			Pass:     access.Pass,
+			Fs:       m.Fs,
+			Params:   m.Params,
+			ReadOnly: m.ReadOnly,
+		}, logger.With("mount", m.Path))
+		if err != nil {
+			return nil, fmt.Errorf("could not load mount %s: %w", m.Path, err)
+		}
+
+		mounts[m.Path] = mountFs
+	}
+
+	return mount.NewFs(root, mounts)
+}
+
+// loadArchive loads an archive from its source file system, described by the "source" and "source.*" params
+func loadArchive(access *confpar.Access, logger *slog.Logger) (afero.Fs, error) {
+	source := afero.NewOsFs()
+
+	if sourceFs := access.Params["source"]; sourceFs != "" {
+		params := make(map[string]string)
+
+		for key, value := range access.Params {
+			if name, ok := strings.CutPrefix(key, "source."); ok {
+				params[name] = value
+			}
+		}
+
+		var err error
+
+		source, err = LoadFs(&confpar.Access{
+			User:   access.User,
+			Pass:   access.Pass,
+			Fs:     sourceFs,
+			Params: params,
+		}, logger.With("component", "archive"))
+		if err != nil {
+			return nil, fmt.Errorf("could not load archive source: %w", err)
+		}
+	}
+
+	return archive.LoadFs(access, source)
+}
@@ -0,0 +1,226 @@
+// Package fsevents provides an afero FS wrapper notifying the file system events
+package fsevents
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/events"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// Notifier is called for each event
+type Notifier func(event *events.Event)
+
+// Fs is a wrapper to notify the changes and transfers on a file system
+type Fs struct {
+	afero.Fs          // Source file system
+	notify   Notifier // Events notifier
+}
+
+// File is a wrapper to notify the completed transfers
+type File struct {
+	afero.File            // Source file
+	notify      Notifier  // Events notifier
+	path        string    // Path of the file
+	write       bool      // If the file was opened for writing
+	start       time.Time // Time the file was opened
+	length      int64     // Bytes read or written
+	eof         bool      // If the end of the file was reached
+	transferErr error     // Transfer error reported by the server
+}
+
+// LoadFS creates an instance notifying the events
+func LoadFS(src afero.Fs, notify Notifier) (afero.Fs, error) {
+	return &Fs{
+		Fs:     src,
+		notify: notify,
+	}, nil
+}
+
+func (f *Fs) wrap(name string, write bool, src afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		File:   src,
+		notify: f.notify,
+		path:   name,
+		write:  write,
+		start:  time.Now(),
+	}, nil
+}
+
+// Create notifies the upload once the file is closed
+func (f *Fs) Create(name string) (afero.File, error) {
+	src, err := f.Fs.Create(name)
+
+	return f.wrap(name, true, src, err)
+}
+
+// Open notifies the download once the file is closed
+func (f *Fs) Open(name string) (afero.File, error) {
+	src, err := f.Fs.Open(name)
+
+	return f.wrap(name, false, src, err)
+}
+
+// OpenFile notifies the upload or download once the file is closed
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	src, err := f.Fs.OpenFile(name, flag, perm)
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+
+	return f.wrap(name, write, src, err)
+}
+
+// Mkdir notifies the created directory
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	err := f.Fs.Mkdir(name, perm)
+	if err == nil {
+		f.notify(&events.Event{Type: events.Mkdir, Path: name})
+	}
+
+	return err
+}
+
+// MkdirAll notifies the created directory
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	err := f.Fs.MkdirAll(name, perm)
+	if err == nil {
+		f.notify(&events.Event{Type: events.Mkdir, Path: name})
+	}
+
+	return err
+}
+
+// Remove notifies the deletion
+func (f *Fs) Remove(name string) error {
+	err := f.Fs.Remove(name)
+	if err == nil {
+		f.notify(&events.Event{Type: events.Delete, Path: name})
+	}
+
+	return err
+}
+
+// RemoveAll notifies the deletion
+func (f *Fs) RemoveAll(name string) error {
+	err := f.Fs.RemoveAll(name)
+	if err == nil {
+		f.notify(&events.Event{Type: events.Delete, Path: name})
+	}
+
+	return err
+}
+
+// Rename notifies the renaming
+func (f *Fs) Rename(oldname, newname string) error {
+	err := f.Fs.Rename(oldname, newname)
+	if err == nil {
+		f.notify(&events.Event{Type: events.Rename, Path: oldname, TargetPath: newname})
+	}
+
+	return err
+}
+
+// SymlinkIfPossible creates a symbolic link on the source file system
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	return utils.Symlink(f.Fs, oldname, newname)
+}
+
+// ReadlinkIfPossible returns the target of a symbolic link on the source file system
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.Fs, name)
+}
+
+// LstatIfPossible returns the info of a file without following symbolic links
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.Fs, name)
+}
+
+// Read counts the bytes read
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.length += int64(n)
+
+	if err == io.EOF {
+		f.eof = true
+	}
+
+	return n, err
+}
+
+// ReadAt counts the bytes read
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.length += int64(n)
+
+	if err == io.EOF {
+		f.eof = true
+	}
+
+	return n, err
+}
+
+// Write counts the bytes written
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.length += int64(n)
+
+	return n, err
+}
+
+// WriteAt counts the bytes written
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.length += int64(n)
+
+	return n, err
+}
+
+// WriteString counts the bytes written
+func (f *File) WriteString(s string) (int, error) {
+	n, err := f.File.WriteString(s)
+	f.length += int64(n)
+
+	return n, err
+}
+
+// TransferError is called by the server when the transfer was interrupted. It implements
+// ftpserverlib's FileTransferError interface.
+func (f *File) TransferError(err error) {
+	f.transferErr = err
+}
+
+// Close notifies the completed upload or download. Directories being listed and interrupted
+// transfers aren't notified.
+func (f *File) Close() error {
+	err := f.File.Close()
+
+	if err != nil || f.transferErr != nil {
+		return err
+	}
+
+	event := &events.Event{
+		Path:     f.path,
+		Size:     f.length,
+		Duration: time.Since(f.start).Seconds(),
+	}
+
+	switch {
+	case f.write:
+		event.Type = events.UploadComplete
+	case f.length > 0 || f.eof:
+		event.Type = events.DownloadComplete
+	default:
+		return nil
+	}
+
+	f.notify(event)
+
+	return nil
+}
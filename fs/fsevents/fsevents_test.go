@@ -0,0 +1,64 @@
+package fsevents
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/events"
+)
+
+func TestTransferEvents(t *testing.T) {
+	var received []*events.Event
+
+	fs, err := LoadFS(afero.NewMemMapFs(), func(event *events.Event) {
+		received = append(received, event)
+	})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/file.txt", []byte("hello"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if _, err := afero.ReadFile(fs, "/file.txt"); err != nil {
+		t.Fatalf("couldn't read file: %v", err)
+	}
+
+	// An interrupted upload isn't notified
+	file, err := fs.Create("/partial.txt")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	file.(*File).TransferError(errors.New("connection reset"))
+	_ = file.Close()
+
+	// A directory listing isn't a download
+	if _, err := afero.ReadDir(fs, "/"); err != nil {
+		t.Fatalf("couldn't list dir: %v", err)
+	}
+
+	if err := fs.Rename("/file.txt", "/renamed.txt"); err != nil {
+		t.Fatalf("couldn't rename file: %v", err)
+	}
+
+	expected := []events.Event{
+		{Type: events.UploadComplete, Path: "/file.txt", Size: 5},
+		{Type: events.DownloadComplete, Path: "/file.txt", Size: 5},
+		{Type: events.Rename, Path: "/file.txt", TargetPath: "/renamed.txt"},
+	}
+
+	if len(received) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(received))
+	}
+
+	for i, want := range expected {
+		have := received[i]
+		if have.Type != want.Type || have.Path != want.Path || have.TargetPath != want.TargetPath || have.Size != want.Size {
+			t.Fatalf("event %d: have:%+v want:%+v", i, have, want)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/fs/utils"
 )
 
 // File is a wrapper to log interactions around file accesses
@@ -103,6 +105,25 @@ func (f *Fs) Stat(name string) (os.FileInfo, error) {
 	return f.src.Stat(name)
 }
 
+// SymlinkIfPossible calls will be logged
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	err := utils.Symlink(f.src, oldname, newname)
+
+	logErr(f.logger, err).Info("Created symlink", "fileName", newname, "target", oldname)
+
+	return err
+}
+
+// ReadlinkIfPossible calls will not be logged
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.src, name)
+}
+
+// LstatIfPossible calls will not be logged
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.src, name)
+}
+
 // Name calls will not be logged
 func (f *Fs) Name() string {
 	return f.src.Name()
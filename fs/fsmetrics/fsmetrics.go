@@ -0,0 +1,193 @@
+// Package fsmetrics provides an afero FS wrapper feeding the server metrics
+package fsmetrics
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/fs/utils"
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+// Fs is a wrapper to count the transfers and errors of a file system
+type Fs struct {
+	afero.Fs                  // Source file system
+	metrics  *metrics.Metrics // Metrics to feed
+	user     string           // User of the session
+	fsType   string           // Type of the backend
+}
+
+// File is a wrapper to count the bytes transferred
+type File struct {
+	afero.File                    // Source file
+	fs         *Fs                // Associated file system
+	start      time.Time          // Time the file was opened
+	read       prometheus.Counter // Bytes downloaded
+	written    prometheus.Counter // Bytes uploaded
+	nbRead     int64              // Bytes read so far
+	nbWritten  int64              // Bytes written so far
+}
+
+// LoadFS creates an instance feeding the metrics
+func LoadFS(src afero.Fs, m *metrics.Metrics, user, fsType string) (afero.Fs, error) {
+	return &Fs{
+		Fs:      src,
+		metrics: m,
+		user:    user,
+		fsType:  fsType,
+	}, nil
+}
+
+// check counts an error, missing files are part of the normal operation
+func (f *Fs) check(op string, err error) error {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		f.metrics.FsErrors.WithLabelValues(f.fsType, op).Inc()
+	}
+
+	return err
+}
+
+func (f *Fs) wrap(op string, src afero.File, err error) (afero.File, error) {
+	if f.check(op, err) != nil {
+		return nil, err
+	}
+
+	return &File{
+		File:    src,
+		fs:      f,
+		start:   time.Now(),
+		read:    f.metrics.TransferredBytes.WithLabelValues(f.user, f.fsType, metrics.Download),
+		written: f.metrics.TransferredBytes.WithLabelValues(f.user, f.fsType, metrics.Upload),
+	}, nil
+}
+
+// Create counts the errors and wraps the file
+func (f *Fs) Create(name string) (afero.File, error) {
+	src, err := f.Fs.Create(name)
+
+	return f.wrap("create", src, err)
+}
+
+// Open counts the errors and wraps the file
+func (f *Fs) Open(name string) (afero.File, error) {
+	src, err := f.Fs.Open(name)
+
+	return f.wrap("open", src, err)
+}
+
+// OpenFile counts the errors and wraps the file
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	src, err := f.Fs.OpenFile(name, flag, perm)
+
+	return f.wrap("open", src, err)
+}
+
+// Mkdir counts the errors
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	return f.check("mkdir", f.Fs.Mkdir(name, perm))
+}
+
+// MkdirAll counts the errors
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	return f.check("mkdir", f.Fs.MkdirAll(name, perm))
+}
+
+// Remove counts the errors
+func (f *Fs) Remove(name string) error {
+	return f.check("remove", f.Fs.Remove(name))
+}
+
+// RemoveAll counts the errors
+func (f *Fs) RemoveAll(name string) error {
+	return f.check("remove", f.Fs.RemoveAll(name))
+}
+
+// Rename counts the errors
+func (f *Fs) Rename(oldname, newname string) error {
+	return f.check("rename", f.Fs.Rename(oldname, newname))
+}
+
+// Stat counts the errors
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	info, err := f.Fs.Stat(name)
+
+	return info, f.check("stat", err)
+}
+
+// SymlinkIfPossible counts the errors
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	return f.check("symlink", utils.Symlink(f.Fs, oldname, newname))
+}
+
+// ReadlinkIfPossible counts the errors
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	target, err := utils.Readlink(f.Fs, name)
+
+	return target, f.check("readlink", err)
+}
+
+// LstatIfPossible counts the errors
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, lstat, err := utils.Lstat(f.Fs, name)
+
+	return info, lstat, f.check("stat", err)
+}
+
+// Read counts the bytes downloaded
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.nbRead += int64(n)
+	f.read.Add(float64(n))
+
+	return n, err
+}
+
+// ReadAt counts the bytes downloaded
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.nbRead += int64(n)
+	f.read.Add(float64(n))
+
+	return n, err
+}
+
+// Write counts the bytes uploaded
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.nbWritten += int64(n)
+	f.written.Add(float64(n))
+
+	return n, f.fs.check("write", err)
+}
+
+// WriteAt counts the bytes uploaded
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.nbWritten += int64(n)
+	f.written.Add(float64(n))
+
+	return n, f.fs.check("write", err)
+}
+
+// WriteString counts the bytes uploaded
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Close records the duration of the transfer
+func (f *File) Close() error {
+	err := f.fs.check("close", f.File.Close())
+	duration := time.Since(f.start).Seconds()
+
+	if f.nbWritten > 0 {
+		f.fs.metrics.TransferDuration.WithLabelValues(f.fs.fsType, metrics.Upload).Observe(duration)
+	} else if f.nbRead > 0 {
+		f.fs.metrics.TransferDuration.WithLabelValues(f.fs.fsType, metrics.Download).Observe(duration)
+	}
+
+	return err
+}
@@ -0,0 +1,57 @@
+package fsmetrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+func TestTransferMetrics(t *testing.T) {
+	m := metrics.NewMetrics()
+
+	fs, err := LoadFS(afero.NewMemMapFs(), m, "test", "os")
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/file.txt", []byte("hello"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if _, err := afero.ReadFile(fs, "/file.txt"); err != nil {
+		t.Fatalf("couldn't read file: %v", err)
+	}
+
+	if _, err := fs.Open("/missing.txt"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	readOnly, _ := LoadFS(afero.NewReadOnlyFs(afero.NewMemMapFs()), m, "test", "os")
+	if err := readOnly.Mkdir("/dir", 0750); err == nil {
+		t.Fatal("expected an error for a read-only fs")
+	}
+
+	if v := testutil.ToFloat64(m.TransferredBytes.WithLabelValues("test", "os", metrics.Upload)); v != 5 {
+		t.Fatalf("unexpected uploaded bytes: %v", v)
+	}
+
+	if v := testutil.ToFloat64(m.TransferredBytes.WithLabelValues("test", "os", metrics.Download)); v != 5 {
+		t.Fatalf("unexpected downloaded bytes: %v", v)
+	}
+
+	if n := testutil.CollectAndCount(m.TransferDuration); n != 2 {
+		t.Fatalf("unexpected number of transfer durations: %d", n)
+	}
+
+	// Missing files aren't errors
+	if v := testutil.ToFloat64(m.FsErrors.WithLabelValues("os", "open")); v != 0 {
+		t.Fatalf("unexpected open errors: %v", v)
+	}
+
+	if v := testutil.ToFloat64(m.FsErrors.WithLabelValues("os", "mkdir")); v != 1 {
+		t.Fatalf("unexpected mkdir errors: %v", v)
+	}
+}
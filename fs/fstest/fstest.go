@@ -0,0 +1,141 @@
+// Package fstest provides the checks shared by the tests of the file system backends
+package fstest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// WriteFile writes a file, failing the test if it can't
+func WriteFile(t *testing.T, fs afero.Fs, name string, content []byte) {
+	t.Helper()
+
+	if err := afero.WriteFile(fs, name, content, 0o644); err != nil {
+		t.Fatalf("couldn't write %s: %v", name, err)
+	}
+}
+
+// CheckContent checks the content of a file
+func CheckContent(t *testing.T, fs afero.Fs, name, expected string) {
+	t.Helper()
+
+	content, err := afero.ReadFile(fs, name)
+	if err != nil || string(content) != expected {
+		t.Fatalf("unexpected content of %s %q: %v", name, content, err)
+	}
+}
+
+// CheckListing checks the names of the entries of a directory, in order
+func CheckListing(t *testing.T, fs afero.Fs, name string, expected ...string) {
+	t.Helper()
+
+	dir, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", name, err)
+	}
+
+	defer func() { _ = dir.Close() }()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil || len(names) != len(expected) {
+		t.Fatalf("unexpected listing of %s %v: %v", name, names, err)
+	}
+
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("unexpected listing of %s %v", name, names)
+		}
+	}
+}
+
+// CheckRandomAccess reads a file whole, then from the middle and from the end
+func CheckRandomAccess(t *testing.T, fs afero.Fs, name string, content []byte) {
+	t.Helper()
+
+	read, err := afero.ReadFile(fs, name)
+	if err != nil || !bytes.Equal(read, content) {
+		t.Fatalf("couldn't read back %s: %v", name, err)
+	}
+
+	file, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", name, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	middle := int64(len(content) / 2)
+	buf := make([]byte, 10)
+
+	if _, err := file.ReadAt(buf, middle); err != nil || !bytes.Equal(buf, content[middle:middle+10]) {
+		t.Fatalf("unexpected ReadAt result %q: %v", buf, err)
+	}
+
+	if _, err := file.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("couldn't seek: %v", err)
+	}
+
+	if rest, err := io.ReadAll(file); err != nil || !bytes.Equal(rest, content[len(content)-4:]) {
+		t.Fatalf("unexpected content after seek %q: %v", rest, err)
+	}
+}
+
+// CheckDirectories creates, lists, renames and removes some directories. It leaves the file system as it found it.
+func CheckDirectories(t *testing.T, fs afero.Fs) {
+	t.Helper()
+
+	if err := fs.Mkdir("/empty", 0o755); err != nil {
+		t.Fatalf("couldn't create dir: %v", err)
+	}
+
+	if err := fs.MkdirAll("/docs/2024", 0o755); err != nil {
+		t.Fatalf("couldn't create dirs: %v", err)
+	}
+
+	WriteFile(t, fs, "/docs/readme.txt", []byte("hello"))
+	WriteFile(t, fs, "/docs/2024/report with spaces.txt", []byte("report"))
+
+	for name, isDir := range map[string]bool{"/empty": true, "/docs": true, "/docs/readme.txt": false} {
+		info, err := fs.Stat(name)
+		if err != nil || info.IsDir() != isDir {
+			t.Fatalf("unexpected stat of %s: %v, %v", name, info, err)
+		}
+	}
+
+	if _, err := fs.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+
+	entries, err := afero.ReadDir(fs, "/docs")
+	if err != nil {
+		t.Fatalf("couldn't read dir: %v", err)
+	}
+
+	if len(entries) != 2 || entries[0].Name() != "2024" || !entries[0].IsDir() ||
+		entries[1].Name() != "readme.txt" || entries[1].Size() != 5 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	if err := fs.Remove("/docs"); err == nil {
+		t.Fatal("a non-empty directory shouldn't be removed")
+	}
+
+	if err := fs.Remove("/empty"); err != nil {
+		t.Fatalf("couldn't remove empty dir: %v", err)
+	}
+
+	if err := fs.Rename("/docs", "/archive"); err != nil {
+		t.Fatalf("couldn't rename dir: %v", err)
+	}
+
+	CheckContent(t, fs, "/archive/2024/report with spaces.txt", "report")
+
+	if err := fs.RemoveAll("/archive"); err != nil {
+		t.Fatalf("couldn't remove dir: %v", err)
+	}
+}
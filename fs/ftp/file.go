@@ -0,0 +1,320 @@
+package ftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// File is an upstream file being read or written, or a directory
+type File struct {
+	fs   *Fs
+	name string
+
+	// Reading
+	info    os.FileInfo
+	offset  int64
+	conn    *ftp.ServerConn // Connection of the running download
+	body    *ftp.Response   // Content from the offset, opened on the first read
+	entries []os.FileInfo   // Directory entries not read yet, listed on the first read
+
+	// Writing
+	writing   bool
+	appending bool
+	size      int64          // Size of the file, and offset of the upload
+	writer    *io.PipeWriter // Content of the running upload, started on the first write
+	uploaded  chan error
+}
+
+// Name returns the name of the file
+func (f *File) Name() string {
+	return f.name
+}
+
+// Close closes the file, and completes its upload
+func (f *File) Close() error {
+	if f.writing {
+		if f.writer == nil {
+			// Nothing was written, the file is still created
+			f.startUpload()
+		}
+
+		_ = f.writer.Close()
+		f.writing = false
+
+		return pathError("close", f.name, <-f.uploaded)
+	}
+
+	return f.closeDownload()
+}
+
+// closeDownload stops the running download, and gives its connection back
+func (f *File) closeDownload() error {
+	if f.body == nil {
+		return nil
+	}
+
+	err := f.body.Close()
+	// The reply to an interrupted transfer varies between servers, the connection isn't trusted anymore
+	f.fs.pool.put(f.conn, err != nil)
+	f.body, f.conn = nil, nil
+
+	return pathError("close", f.name, err)
+}
+
+// Read reads the file from the current offset
+func (f *File) Read(p []byte) (int, error) {
+	if f.writing || f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrNotSupported}
+	}
+
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		conn, err := f.fs.pool.get()
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		body, err := conn.RetrFrom(f.fs.path(f.name), uint64(f.offset)) //nolint:gosec // offset is positive
+		if err != nil {
+			f.fs.pool.put(conn, isBroken(err))
+
+			return 0, pathError("read", f.name, err)
+		}
+
+		f.conn, f.body = conn, body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads a part of the file
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.writing || f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrNotSupported}
+	}
+
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	conn, err := f.fs.pool.get()
+	if err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	body, err := conn.RetrFrom(f.fs.path(f.name), uint64(off)) //nolint:gosec // offset is positive
+	if err != nil {
+		f.fs.pool.put(conn, isBroken(err))
+
+		return 0, pathError("read", f.name, err)
+	}
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	f.fs.pool.put(conn, body.Close() != nil)
+
+	return n, err
+}
+
+// Seek moves the read offset, the file is then downloaded again from there.
+// Before the first write, it sets the offset of the upload.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.writing {
+		switch {
+		case offset == f.size && whence == io.SeekStart || offset == 0 && whence == io.SeekCurrent:
+			return f.size, nil
+		case f.writer == nil && !f.appending && whence == io.SeekStart && offset >= 0 && offset <= f.size:
+			// Resumed upload, with REST then STOR
+			f.size = offset
+
+			return offset, nil
+		default:
+			return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrNotSupported}
+		}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if offset != f.offset {
+		if err := f.closeDownload(); err != nil {
+			return 0, err
+		}
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// startUpload starts the STOR or APPE command, fed by the writes
+func (f *File) startUpload() {
+	reader, writer := io.Pipe()
+	f.writer = writer
+	f.uploaded = make(chan error, 1)
+	offset := uint64(f.size) //nolint:gosec // size is positive
+
+	go func() {
+		conn, err := f.fs.pool.get()
+		if err == nil {
+			switch {
+			case f.appending:
+				err = conn.Append(f.fs.path(f.name), reader)
+			default:
+				err = conn.StorFrom(f.fs.path(f.name), reader, offset)
+			}
+
+			f.fs.pool.put(conn, isBroken(err))
+		}
+
+		// Unblocks the writes when the upload failed
+		_ = reader.CloseWithError(err)
+		f.uploaded <- err
+	}()
+}
+
+// Write streams some content to the upload
+func (f *File) Write(p []byte) (int, error) {
+	if !f.writing {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	if f.writer == nil {
+		f.startUpload()
+	}
+
+	n, err := f.writer.Write(p)
+	f.size += int64(n)
+
+	if err != nil {
+		return n, pathError("write", f.name, err)
+	}
+
+	return n, nil
+}
+
+// WriteAt is not supported, files are uploaded sequentially
+func (f *File) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: ErrNotSupported}
+}
+
+// WriteString writes a string to the file
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Readdir returns the entries of a directory
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if f.info == nil || !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if f.entries == nil {
+		entries, err := f.fs.readDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		f.entries = entries
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = []os.FileInfo{}
+
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(f.entries))
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+
+	return entries, nil
+}
+
+// Readdirnames returns the names of the entries of a directory
+func (f *File) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+
+	return names, err
+}
+
+// Stat returns the info of the file
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.info != nil {
+		return f.info, nil
+	}
+
+	return &fileInfo{name: path.Base(f.name), size: f.size, modTime: time.Now()}, nil
+}
+
+// Sync does nothing, the file is stored when the upload completes on close
+func (f *File) Sync() error {
+	return nil
+}
+
+// Truncate is not supported
+func (f *File) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: ErrNotSupported}
+}
+
+// fileInfo describes an upstream file or directory
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func newFileInfo(name string, entry *ftp.Entry) *fileInfo {
+	return &fileInfo{
+		name:    name,
+		size:    int64(entry.Size), //nolint:gosec // sizes fit
+		modTime: entry.Time,
+		dir:     entry.Type == ftp.EntryTypeFolder,
+	}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+
+	return 0o644
+}
@@ -0,0 +1,489 @@
+// Package ftp provides an access layer to an upstream FTP or FTPS server
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Default values of the parameters
+const (
+	DefaultMaxConnections = 5
+	DefaultTimeout        = 30 * time.Second
+	DefaultIdleTimeout    = time.Minute
+)
+
+// ErrNoHostname is returned when the hostname parameter is missing
+var ErrNoHostname = errors.New("hostname parameter is required for ftp")
+
+// ErrInvalidTLSMode is returned when the tls parameter is neither empty, "explicit" nor "implicit"
+var ErrInvalidTLSMode = errors.New(`tls parameter must be "explicit" or "implicit"`)
+
+// ErrInvalidMaxConnections is returned when the max_connections parameter isn't a positive number
+var ErrInvalidMaxConnections = errors.New("max_connections parameter must be a positive number")
+
+// ErrInvalidCA is returned when the CA bundle doesn't contain any certificate
+var ErrInvalidCA = errors.New("no certificate found in the CA bundle")
+
+// ErrNotSupported is returned for the operations the FTP protocol can't do, like random writes
+var ErrNotSupported = errors.New("not supported by ftp")
+
+// ConnectionError is returned when the upstream FTP server can't be reached or refuses the login
+type ConnectionError struct {
+	Source error
+}
+
+func (err ConnectionError) Error() string {
+	return fmt.Sprintf("Could not connect to FTP host: %v", err.Source)
+}
+
+func (err ConnectionError) Unwrap() error {
+	return err.Source
+}
+
+// Fs is a directory of an upstream FTP server exposed as a file system
+type Fs struct {
+	pool     *pool
+	basePath string
+}
+
+// LoadFs loads a file system from an access description
+func LoadFs(access *confpar.Access) (afero.Fs, error) {
+	par := access.Params
+
+	if par["hostname"] == "" {
+		return nil, ErrNoHostname
+	}
+
+	options, err := dialOptions(par)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConns := DefaultMaxConnections
+	if value := par["max_connections"]; value != "" {
+		if maxConns, err = strconv.Atoi(value); err != nil || maxConns < 1 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMaxConnections, value)
+		}
+	}
+
+	idleTimeout, err := duration(par, "idle_timeout", DefaultIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	address := par["hostname"]
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		port := "21"
+		if par["tls"] == "implicit" {
+			port = "990"
+		}
+
+		address = net.JoinHostPort(address, port)
+	}
+
+	user, password := par["username"], par["password"]
+	if user == "" {
+		user, password = "anonymous", "anonymous"
+	}
+
+	dial := func() (*ftp.ServerConn, error) {
+		conn, err := ftp.Dial(address, options...)
+		if err != nil {
+			return nil, &ConnectionError{Source: err}
+		}
+
+		if err := conn.Login(user, password); err != nil {
+			_ = conn.Quit()
+
+			return nil, &ConnectionError{Source: err}
+		}
+
+		return conn, nil
+	}
+
+	key, err := json.Marshal(access)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Fs{
+		pool:     getPool(string(key), func() *pool { return newPool(dial, maxConns, idleTimeout) }),
+		basePath: path.Clean("/" + par["basePath"]),
+	}
+
+	// Checks the connection, which then stays in the pool for the first operations
+	conn, err := fs.pool.get()
+	if err != nil {
+		return nil, err
+	}
+
+	fs.pool.put(conn, false)
+
+	return fs, nil
+}
+
+// dialOptions converts the access parameters to the options of the FTP connections
+func dialOptions(par map[string]string) ([]ftp.DialOption, error) {
+	timeout, err := duration(par, "timeout", DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []ftp.DialOption{
+		ftp.DialWithTimeout(timeout),
+		ftp.DialWithShutTimeout(timeout),
+		ftp.DialWithDisabledEPSV(par["disable_epsv"] == "true"),
+	}
+
+	if par["tls"] == "" {
+		return options, nil
+	}
+
+	host := par["hostname"]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	tlsConf := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: par["insecure_skip_verify"] == "true", //nolint:gosec // explicitly opted-in by the user
+		// Data connections resume the session of the control connection, as many servers require it
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if ca := par["ca"]; ca != "" {
+		caBytes, err := os.ReadFile(ca) //nolint:gosec // path from the configuration
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA file: %s: %w", ca, err)
+		}
+
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, ca)
+		}
+	}
+
+	switch par["tls"] {
+	case "explicit":
+		return append(options, ftp.DialWithExplicitTLS(tlsConf)), nil
+	case "implicit":
+		return append(options, ftp.DialWithTLS(tlsConf)), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTLSMode, par["tls"])
+	}
+}
+
+func duration(par map[string]string, name string, defaultValue time.Duration) (time.Duration, error) {
+	value := par[name]
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ftp %s %q: %w", name, value, err)
+	}
+
+	return d, nil
+}
+
+// Name of the file system
+func (fs *Fs) Name() string {
+	return "ftp"
+}
+
+// path returns the path of a file on the upstream server
+func (fs *Fs) path(name string) string {
+	return path.Join(fs.basePath, path.Clean("/"+name))
+}
+
+// withConn runs an operation with a pooled connection
+func (fs *Fs) withConn(op, name string, run func(conn *ftp.ServerConn) error) error {
+	conn, err := fs.pool.get()
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	err = run(conn)
+	fs.pool.put(conn, isBroken(err))
+
+	return pathError(op, name, err)
+}
+
+// isBroken tells if an error left the connection unusable, replies of the server don't
+func isBroken(err error) bool {
+	var protoErr *textproto.Error
+
+	return err != nil && !errors.As(err, &protoErr)
+}
+
+// pathError converts the replies of the server to the matching file system errors
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		switch protoErr.Code {
+		case ftp.StatusFileUnavailable:
+			err = fmt.Errorf("%w: %s", os.ErrNotExist, protoErr.Msg)
+		case ftp.StatusNotLoggedIn:
+			err = fmt.Errorf("%w: %s", os.ErrPermission, protoErr.Msg)
+		}
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Create creates a file
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// Mkdir creates a directory with MKD
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	err := fs.withConn("mkdir", name, func(conn *ftp.ServerConn) error {
+		return conn.MakeDir(fs.path(name))
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		// The reply doesn't tell if the directory exists or if its parent is missing
+		if _, errStat := fs.Stat(name); errStat == nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+	}
+
+	return err
+}
+
+// MkdirAll creates a directory and all its missing parents
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	current := "/"
+
+	for _, part := range strings.Split(path.Clean("/"+name), "/") {
+		if part == "" {
+			continue
+		}
+
+		current = path.Join(current, part)
+
+		info, err := fs.Stat(current)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+
+			continue
+		}
+
+		if err := fs.Mkdir(current, perm); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open opens a file or a directory for reading
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file for reading with RETR, or for writing with STOR or APPE
+func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &File{fs: fs, name: name, info: info}, nil
+	}
+
+	if flag&os.O_RDWR != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+
+	info, err := fs.Stat(name)
+
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+
+	file := &File{fs: fs, name: name, writing: true, appending: flag&os.O_APPEND != 0}
+	if err == nil && flag&(os.O_TRUNC|os.O_APPEND) == 0 {
+		// The file is kept, so that a seek followed by a write resumes its upload
+		file.size = info.Size()
+	}
+
+	return file, nil
+}
+
+// Remove removes a file with DELE, or an empty directory with RMD
+func (fs *Fs) Remove(name string) error {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	return fs.withConn("remove", name, func(conn *ftp.ServerConn) error {
+		if info.IsDir() {
+			return conn.RemoveDir(fs.path(name))
+		}
+
+		return conn.Delete(fs.path(name))
+	})
+}
+
+// RemoveAll removes a file or a directory and all its content
+func (fs *Fs) RemoveAll(name string) error {
+	info, err := fs.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := fs.readDir(name)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := fs.RemoveAll(path.Join(name, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return fs.Remove(name)
+}
+
+// Rename moves a file or a directory with RNFR and RNTO
+func (fs *Fs) Rename(oldname, newname string) error {
+	return fs.withConn("rename", oldname, func(conn *ftp.ServerConn) error {
+		return conn.Rename(fs.path(oldname), fs.path(newname))
+	})
+}
+
+// Stat returns the info of a file or a directory, with MLST or by listing its parent directory
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	name = path.Clean("/" + name)
+	if fs.path(name) == "/" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	var info os.FileInfo
+
+	err := fs.withConn("stat", name, func(conn *ftp.ServerConn) error {
+		entry, err := conn.GetEntry(fs.path(name))
+
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotImplemented {
+			entry, err = findEntry(conn, fs.path(name))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		info = newFileInfo(path.Base(name), entry)
+
+		return nil
+	})
+
+	return info, err
+}
+
+// findEntry looks for a file in the listing of its parent directory, for the servers not supporting MLST
+func findEntry(conn *ftp.ServerConn, name string) (*ftp.Entry, error) {
+	entries, err := conn.List(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == path.Base(name) {
+			return entry, nil
+		}
+	}
+
+	return nil, &textproto.Error{Code: ftp.StatusFileUnavailable, Msg: "file not found"}
+}
+
+// readDir returns the files and directories of a directory, with MLSD or LIST
+func (fs *Fs) readDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+
+	err := fs.withConn("readdir", name, func(conn *ftp.ServerConn) error {
+		entries, err := conn.List(fs.path(name))
+		if err != nil {
+			return err
+		}
+
+		infos = make([]os.FileInfo, 0, len(entries))
+
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+
+			infos = append(infos, newFileInfo(entry.Name, entry))
+		}
+
+		return nil
+	})
+
+	return infos, err
+}
+
+// Chmod is not supported, and ignored
+func (fs *Fs) Chmod(string, os.FileMode) error {
+	return nil
+}
+
+// Chown is not supported, and ignored
+func (fs *Fs) Chown(string, int, int) error {
+	return nil
+}
+
+// Chtimes changes the modification time with MFMT, when the server supports it
+func (fs *Fs) Chtimes(name string, _ time.Time, mtime time.Time) error {
+	return fs.withConn("chtimes", name, func(conn *ftp.ServerConn) error {
+		if !conn.IsSetTimeSupported() {
+			return nil
+		}
+
+		return conn.SetTime(fs.path(name), mtime)
+	})
+}
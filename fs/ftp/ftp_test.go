@@ -0,0 +1,299 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/fstest"
+)
+
+// testDriver serves a temporary directory to the "alice" user
+type testDriver struct {
+	fs          afero.Fs
+	settings    *serverlib.Settings
+	tlsConfig   *tls.Config
+	connections atomic.Int32
+}
+
+func (d *testDriver) GetSettings() (*serverlib.Settings, error) { return d.settings, nil }
+
+func (d *testDriver) ClientConnected(serverlib.ClientContext) (string, error) {
+	d.connections.Add(1)
+
+	return "test server", nil
+}
+
+func (d *testDriver) ClientDisconnected(serverlib.ClientContext) {}
+
+func (d *testDriver) AuthUser(_ serverlib.ClientContext, user, pass string) (serverlib.ClientDriver, error) {
+	if user != "alice" || pass != "secret" {
+		return nil, os.ErrPermission
+	}
+
+	return d.fs, nil
+}
+
+func (d *testDriver) GetTLSConfig() (*tls.Config, error) {
+	if d.tlsConfig == nil {
+		return nil, errors.New("no TLS") //nolint:err113 // test only
+	}
+
+	return d.tlsConfig, nil
+}
+
+func newTestServer(t *testing.T, settings *serverlib.Settings, tlsConfig *tls.Config) *testDriver {
+	t.Helper()
+
+	settings.ListenAddr = "127.0.0.1:0"
+	driver := &testDriver{
+		fs:        afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()),
+		settings:  settings,
+		tlsConfig: tlsConfig,
+	}
+	server := serverlib.NewFtpServer(driver)
+
+	if err := server.Listen(); err != nil {
+		t.Fatalf("couldn't listen: %v", err)
+	}
+
+	go func() { _ = server.Serve() }()
+
+	t.Cleanup(func() { _ = server.Stop() })
+
+	settings.ListenAddr = server.Addr()
+
+	return driver
+}
+
+// loadFs loads a file system on the server, logged in as alice
+func (d *testDriver) loadFs(t *testing.T, params map[string]string) *Fs {
+	t.Helper()
+
+	params["hostname"] = d.settings.ListenAddr
+	params["username"] = "alice"
+	params["password"] = "secret"
+
+	fs, err := LoadFs(&confpar.Access{User: t.Name(), Fs: "ftp", Params: params})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs.(*Fs)
+}
+
+func TestTransfers(t *testing.T) {
+	driver := newTestServer(t, &serverlib.Settings{}, nil)
+	fs := driver.loadFs(t, map[string]string{"basePath": "/users/alice", "max_connections": "2"})
+
+	if err := driver.fs.MkdirAll("/users/alice", 0o755); err != nil {
+		t.Fatalf("couldn't create base path: %v", err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 100*1024)
+	fstest.WriteFile(t, fs, "/big.bin", content)
+	fstest.CheckContent(t, driver.fs, "/users/alice/big.bin", string(content))
+
+	// Reads at an offset use REST and RETR
+	fstest.CheckRandomAccess(t, fs, "/big.bin", content)
+
+	file, err := fs.Open("/big.bin")
+	if err != nil {
+		t.Fatalf("couldn't open file: %v", err)
+	}
+
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(file, buf); err != nil || string(buf) != "0123456789" {
+		t.Fatalf("unexpected Read result %q: %v", buf, err)
+	}
+
+	// Moves the offset in the middle of a download
+	if _, err := file.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("couldn't seek: %v", err)
+	}
+
+	if rest, err := io.ReadAll(file); err != nil || string(rest) != "6789" {
+		t.Fatalf("unexpected content after seek %q: %v", rest, err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close: %v", err)
+	}
+
+	// Appended with APPE
+	file, err = fs.OpenFile("/big.bin", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("couldn't open for append: %v", err)
+	}
+
+	if _, err := file.WriteString("appended"); err != nil {
+		t.Fatalf("couldn't append: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close: %v", err)
+	}
+
+	if info, err := fs.Stat("/big.bin"); err != nil || info.Size() != int64(len(content))+8 {
+		t.Fatalf("unexpected stat after append: %v, %v", info, err)
+	}
+
+	// Resumed with REST and STOR
+	fstest.WriteFile(t, fs, "/resumed.txt", []byte("hello wor"))
+
+	file, err = fs.OpenFile("/resumed.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("couldn't open for resume: %v", err)
+	}
+
+	if _, err := file.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("couldn't seek: %v", err)
+	}
+
+	if _, err := file.WriteString("world"); err != nil {
+		t.Fatalf("couldn't write: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close: %v", err)
+	}
+
+	fstest.CheckContent(t, fs, "/resumed.txt", "hello world")
+
+	// The control connections are reused, even by another session of the same access
+	driver.loadFs(t, map[string]string{"basePath": "/users/alice", "max_connections": "2"})
+
+	if _, err := fs.Stat("/resumed.txt"); err != nil {
+		t.Fatalf("couldn't stat: %v", err)
+	}
+
+	if n := driver.connections.Load(); n > 2 {
+		t.Fatalf("expected at most 2 connections, got %d", n)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	for name, settings := range map[string]*serverlib.Settings{
+		"MLSD": {},
+		"LIST": {DisableMLSD: true, DisableMLST: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			driver := newTestServer(t, settings, nil)
+			fs := driver.loadFs(t, map[string]string{"disable_epsv": "true"})
+
+			fstest.CheckDirectories(t, fs)
+
+			// The reply codes are mapped to the os errors
+			if err := fs.Mkdir("/dir", 0o755); err != nil {
+				t.Fatalf("couldn't create dir: %v", err)
+			}
+
+			if err := fs.Mkdir("/dir", 0o755); !errors.Is(err, os.ErrExist) {
+				t.Fatalf("expected ErrExist, got %v", err)
+			}
+
+			if info, err := fs.Stat("/"); err != nil || !info.IsDir() {
+				t.Fatalf("unexpected stat of /: %v, %v", info, err)
+			}
+
+			fstest.CheckListing(t, driver.fs, "/", "dir")
+		})
+	}
+}
+
+// writeCA writes a self-signed certificate for 127.0.0.1, and returns its TLS config
+func writeCA(t *testing.T, caFile string) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldn't create certificate: %v", err)
+	}
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("couldn't write CA: %v", err)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func TestTLS(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	tlsConfig := writeCA(t, caFile)
+
+	for mode, requirement := range map[string]serverlib.TLSRequirement{
+		"explicit": serverlib.MandatoryEncryption,
+		"implicit": serverlib.ImplicitEncryption,
+	} {
+		t.Run(mode, func(t *testing.T) {
+			driver := newTestServer(t, &serverlib.Settings{TLSRequired: requirement}, tlsConfig)
+			fs := driver.loadFs(t, map[string]string{"tls": mode, "ca": caFile})
+
+			fstest.WriteFile(t, fs, "/file.txt", []byte("hello"))
+			fstest.CheckContent(t, fs, "/file.txt", "hello")
+		})
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		driver := newTestServer(t, &serverlib.Settings{TLSRequired: serverlib.MandatoryEncryption}, tlsConfig)
+		params := map[string]string{"hostname": driver.settings.ListenAddr, "tls": "explicit"}
+
+		var connErr *ConnectionError
+		if _, err := LoadFs(&confpar.Access{Fs: "ftp", Params: params}); !errors.As(err, &connErr) {
+			t.Fatalf("expected a connection error, got %v", err)
+		}
+	})
+}
+
+func TestLoadFsErrors(t *testing.T) {
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{}}); !errors.Is(err, ErrNoHostname) {
+		t.Fatalf("expected ErrNoHostname, got %v", err)
+	}
+
+	params := map[string]string{"hostname": "localhost", "tls": "sometimes"}
+	if _, err := LoadFs(&confpar.Access{Params: params}); !errors.Is(err, ErrInvalidTLSMode) {
+		t.Fatalf("expected ErrInvalidTLSMode, got %v", err)
+	}
+
+	driver := newTestServer(t, &serverlib.Settings{}, nil)
+	params = map[string]string{"hostname": driver.settings.ListenAddr, "username": "alice", "password": "wrong"}
+
+	var connErr *ConnectionError
+	if _, err := LoadFs(&confpar.Access{Params: params}); !errors.As(err, &connErr) {
+		t.Fatalf("expected a connection error, got %v", err)
+	}
+}
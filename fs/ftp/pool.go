@@ -0,0 +1,137 @@
+package ftp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// pool keeps the control connections of an access, to reuse them between operations and sessions
+type pool struct {
+	dial        func() (*ftp.ServerConn, error)
+	slots       chan struct{} // One per open connection, limits their number
+	idleTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []*idleConn
+}
+
+type idleConn struct {
+	conn  *ftp.ServerConn
+	since time.Time
+	timer *time.Timer // Closes the connection when it stays idle for too long
+}
+
+// checkAfter is the idle time after which a connection is checked with a NOOP before being reused
+const checkAfter = 10 * time.Second
+
+// pools contains the pool of each access
+var pools = struct {
+	sync.Mutex
+	byKey map[string]*pool
+}{byKey: make(map[string]*pool)}
+
+// getPool returns the pool of an access, and creates it if needed
+func getPool(key string, create func() *pool) *pool {
+	pools.Lock()
+	defer pools.Unlock()
+
+	p := pools.byKey[key]
+	if p == nil {
+		p = create()
+		pools.byKey[key] = p
+	}
+
+	return p
+}
+
+func newPool(dial func() (*ftp.ServerConn, error), maxConns int, idleTimeout time.Duration) *pool {
+	return &pool{
+		dial:        dial,
+		slots:       make(chan struct{}, maxConns),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// get returns an idle connection or a new one, it waits when all the connections are in use
+func (p *pool) get() (*ftp.ServerConn, error) {
+	p.slots <- struct{}{}
+
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+
+			break
+		}
+
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		ic.timer.Stop()
+
+		if time.Since(ic.since) < checkAfter || ic.conn.NoOp() == nil {
+			return ic.conn, nil
+		}
+
+		// The server closed the connection in the meantime
+		_ = ic.conn.Quit()
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// put gives a connection back, broken connections are closed instead of being reused
+func (p *pool) put(conn *ftp.ServerConn, broken bool) {
+	defer func() { <-p.slots }()
+
+	if broken {
+		_ = conn.Quit()
+
+		return
+	}
+
+	ic := &idleConn{conn: conn, since: time.Now()}
+	ic.timer = time.AfterFunc(p.idleTimeout, func() { p.expire(ic) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.idle = append(p.idle, ic)
+}
+
+// expire closes a connection that stayed idle for too long, unless it was taken in between
+func (p *pool) expire(ic *idleConn) {
+	p.mu.Lock()
+
+	for i, other := range p.idle {
+		if other == ic {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			p.mu.Unlock()
+
+			_ = ic.conn.Quit()
+
+			return
+		}
+	}
+
+	p.mu.Unlock()
+}
+
+// size returns the number of idle connections
+func (p *pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle)
+}
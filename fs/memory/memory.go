@@ -0,0 +1,161 @@
+// Package memory provides an in-memory access layer
+package memory
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/quota"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// minPurgeInterval limits how often the expired files are looked for
+const minPurgeInterval = time.Second
+
+// Fs is an in-memory file system, whose files can expire
+type Fs struct {
+	afero.Fs                       // Memory file system, possibly wrapped to enforce the max_bytes cap
+	ttl       time.Duration        // Time after which the files are purged, 0 to keep them
+	seeded    map[string]time.Time // Modification time of the seed files, which don't expire unless modified
+	now       func() time.Time
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// LoadFs loads a file system from an access description
+func LoadFs(access *confpar.Access) (afero.Fs, error) {
+	par := access.Params
+	mem := afero.NewMemMapFs()
+
+	fs := &Fs{Fs: mem, seeded: make(map[string]time.Time), now: time.Now}
+
+	if seed := par["seed"]; seed != "" {
+		if err := fs.seed(utils.ReplaceEnvVars(seed)); err != nil {
+			return nil, fmt.Errorf("could not seed memory fs from %s: %w", seed, err)
+		}
+	}
+
+	if value := par["ttl"]; value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory ttl %q: %w", value, err)
+		}
+
+		fs.ttl = ttl
+	}
+
+	if value := par["max_bytes"]; value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory max_bytes %q: %w", value, err)
+		}
+
+		usage, err := quota.NewUsages().Get(access.User, mem, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if fs.Fs, err = quota.LoadFS(mem, usage, &confpar.Quota{MaxBytes: maxBytes}); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// Name of the file system
+func (fs *Fs) Name() string {
+	return "memory"
+}
+
+// purge removes the expired files, at most once per tenth of the TTL
+func (fs *Fs) purge() {
+	if fs.ttl <= 0 {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := fs.now()
+	if now.Sub(fs.lastPurge) < max(fs.ttl/10, minPurgeInterval) {
+		return
+	}
+
+	fs.lastPurge = now
+
+	var expired []string
+
+	_ = afero.Walk(fs.Fs, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || now.Sub(info.ModTime()) < fs.ttl {
+			return nil
+		}
+
+		if modTime, ok := fs.seeded[name]; ok && modTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		expired = append(expired, name)
+
+		return nil
+	})
+
+	for _, name := range expired {
+		_ = fs.Fs.Remove(name)
+	}
+}
+
+// Create creates a file
+func (fs *Fs) Create(name string) (afero.File, error) {
+	fs.purge()
+
+	return fs.Fs.Create(name)
+}
+
+// Mkdir creates a directory
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	fs.purge()
+
+	return fs.Fs.Mkdir(name, perm)
+}
+
+// MkdirAll creates a directory and all its missing parents
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	fs.purge()
+
+	return fs.Fs.MkdirAll(name, perm)
+}
+
+// Open opens a file or a directory for reading
+func (fs *Fs) Open(name string) (afero.File, error) {
+	fs.purge()
+
+	return fs.Fs.Open(name)
+}
+
+// OpenFile opens a file
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs.purge()
+
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+// Rename moves a file or a directory
+func (fs *Fs) Rename(oldname, newname string) error {
+	fs.purge()
+
+	return fs.Fs.Rename(oldname, newname)
+}
+
+// Stat returns the info of a file or a directory
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	fs.purge()
+
+	return fs.Fs.Stat(name)
+}
@@ -0,0 +1,160 @@
+package memory
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/fstest"
+	"github.com/fclairamb/ftpserver/fs/quota"
+)
+
+func loadTestFs(t *testing.T, params map[string]string) *Fs {
+	t.Helper()
+
+	fs, err := LoadFs(&confpar.Access{User: "test", Fs: "memory", Params: params})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs.(*Fs)
+}
+
+func TestSeedFromDir(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := os.MkdirAll(filepath.Join(dir, "docs", "empty"), 0o755); err != nil {
+		t.Fatalf("couldn't create dirs: %v", err)
+	}
+
+	for name, content := range map[string]string{"readme.txt": "hello", "docs/guide.txt": "guide"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("couldn't write %s: %v", name, err)
+		}
+
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatalf("couldn't change times: %v", err)
+		}
+	}
+
+	fs := loadTestFs(t, map[string]string{"seed": dir})
+
+	fstest.CheckContent(t, fs, "/readme.txt", "hello")
+	fstest.CheckContent(t, fs, "/docs/guide.txt", "guide")
+
+	if info, err := fs.Stat("/docs/empty"); err != nil || !info.IsDir() {
+		t.Fatalf("unexpected stat of the empty dir: %v, %v", info, err)
+	}
+
+	if info, err := fs.Stat("/readme.txt"); err != nil || !info.ModTime().Equal(modTime) {
+		t.Fatalf("the modification time should be kept: %v, %v", info, err)
+	}
+
+	// The seed content is copied, not shared
+	if err := fs.Remove("/readme.txt"); err != nil {
+		t.Fatalf("couldn't remove: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "readme.txt")); err != nil {
+		t.Fatalf("the seed directory shouldn't change: %v", err)
+	}
+}
+
+func TestSeedFromTar(t *testing.T) {
+	var buf bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, header := range []*tar.Header{
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "data/2024/values.csv", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "data"},
+	} {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("couldn't write header: %v", err)
+		}
+
+		if header.Size > 0 {
+			_, _ = tarWriter.Write([]byte("1,2,3"))
+		}
+	}
+
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+
+	tarFile := filepath.Join(t.TempDir(), "seed.tar.gz")
+	if err := os.WriteFile(tarFile, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("couldn't write tar: %v", err)
+	}
+
+	fs := loadTestFs(t, map[string]string{"seed": tarFile})
+
+	fstest.CheckContent(t, fs, "/data/2024/values.csv", "1,2,3")
+
+	if _, err := fs.Stat("/link"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("symbolic links should be skipped: %v", err)
+	}
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"seed": "/does/not/exist"}}); err == nil {
+		t.Fatal("a missing seed should fail")
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	fs := loadTestFs(t, map[string]string{"max_bytes": "10"})
+
+	fstest.WriteFile(t, fs, "/small.txt", []byte("hello"))
+
+	if err := afero.WriteFile(fs, "/big.txt", []byte("hello world"), 0o644); !errors.Is(err, quota.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	if err := fs.Remove("/small.txt"); err != nil {
+		t.Fatalf("couldn't remove: %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/other.txt", []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("the space should be released: %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("hello"), 0o600); err != nil {
+		t.Fatalf("couldn't write seed: %v", err)
+	}
+
+	fs := loadTestFs(t, map[string]string{"seed": dir, "ttl": "1h", "max_bytes": "20"})
+	now := time.Now()
+	fs.now = func() time.Time { return now }
+
+	fstest.WriteFile(t, fs, "/upload.txt", []byte("0123456789"))
+
+	now = now.Add(30 * time.Minute)
+	fstest.CheckContent(t, fs, "/upload.txt", "0123456789")
+
+	now = now.Add(time.Hour)
+
+	if _, err := fs.Stat("/upload.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the upload should be purged: %v", err)
+	}
+
+	fstest.CheckContent(t, fs, "/readme.txt", "hello")
+
+	// The purged bytes are released
+	fstest.WriteFile(t, fs, "/upload.txt", []byte("0123456789"))
+
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{"ttl": "soon"}}); err == nil {
+		t.Fatal("an invalid ttl should fail")
+	}
+}
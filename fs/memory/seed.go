@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// gzipMagic starts the gzip compressed files
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// seed copies the content of a local directory or tar file
+func (fs *Fs) seed(source string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return fs.seedFromDir(source)
+	}
+
+	return fs.seedFromTar(source)
+}
+
+// seedFromDir copies the files and directories of a local directory
+func (fs *Fs) seedFromDir(dir string) error {
+	src := afero.NewBasePathFs(afero.NewOsFs(), dir)
+
+	return afero.Walk(src, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name = path.Clean("/" + filepath.ToSlash(name))
+
+		switch {
+		case info.IsDir():
+			return fs.Fs.MkdirAll(name, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			file, err := src.Open(name)
+			if err != nil {
+				return err
+			}
+
+			defer func() { _ = file.Close() }()
+
+			return fs.seedFile(name, file, info)
+		default:
+			// Symbolic links and special files are skipped
+			return nil
+		}
+	})
+}
+
+// seedFromTar copies the files and directories of a tar file, possibly gzip compressed
+func (fs *Fs) seedFromTar(tarFile string) error {
+	file, err := os.Open(tarFile) //nolint:gosec // path from the configuration
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var reader io.Reader = bufio.NewReader(file)
+
+	if magic, _ := reader.(*bufio.Reader).Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = gzipReader.Close() }()
+
+		reader = gzipReader
+	}
+
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid tar file: %w", err)
+		}
+
+		name := path.Clean("/" + header.Name)
+		info := header.FileInfo()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := fs.Fs.MkdirAll(name, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := fs.Fs.MkdirAll(path.Dir(name), 0o755); err != nil {
+				return err
+			}
+
+			if err := fs.seedFile(name, archive, info); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seedFile copies a file, and keeps its modification time
+func (fs *Fs) seedFile(name string, content io.Reader, info os.FileInfo) error {
+	file, err := fs.Fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, content); err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := fs.Fs.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	fs.seeded[name] = info.ModTime()
+
+	return nil
+}
@@ -0,0 +1,459 @@
+// Package mount provides an afero FS routing paths to the file systems mounted on them
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/fs/stripprefix"
+)
+
+// ErrInvalidMountPath is returned when a mount path isn't an absolute and clean path other than "/"
+var ErrInvalidMountPath = errors.New("invalid mount path")
+
+// ErrDuplicateMountPath is returned when two file systems are mounted on the same path
+var ErrDuplicateMountPath = errors.New("duplicate mount path")
+
+// ErrCrossMount is returned when renaming a file from a mount to another one
+var ErrCrossMount = errors.New("cannot rename across mounts")
+
+// ErrMountPoint is returned when trying to remove or rename a mount point or one of its parents
+var ErrMountPoint = errors.New("cannot change a mount point")
+
+// mountPoint is a file system mounted on a path
+type mountPoint struct {
+	path string   // Path where the file system is mounted
+	fs   afero.Fs // File system, seen from the mount path
+}
+
+// Fs routes each path to the file system mounted on its longest matching prefix
+type Fs struct {
+	root   afero.Fs      // File system used when no mount matches, can be nil
+	mounts []*mountPoint // Mount points, longest paths first
+}
+
+// NewFs creates a mount table. The root can be nil, in which case the parent directories of the
+// mount points are the only directories outside the mounts.
+func NewFs(root afero.Fs, mounts map[string]afero.Fs) (*Fs, error) {
+	fs := &Fs{
+		root:   root,
+		mounts: make([]*mountPoint, 0, len(mounts)),
+	}
+
+	for mountPath, mountFs := range mounts {
+		if !path.IsAbs(mountPath) || path.Clean(mountPath) != mountPath || mountPath == "/" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMountPath, mountPath)
+		}
+
+		fs.mounts = append(fs.mounts, &mountPoint{
+			path: mountPath,
+			fs:   stripprefix.NewStripPrefixFs(mountFs, len(mountPath)),
+		})
+	}
+
+	sort.Slice(fs.mounts, func(i, j int) bool {
+		return len(fs.mounts[i].path) > len(fs.mounts[j].path)
+	})
+
+	return fs, nil
+}
+
+// route returns the mount point of a path, or nil if the path isn't within any mount
+func (f *Fs) route(name string) *mountPoint {
+	for _, m := range f.mounts {
+		if name == m.path || strings.HasPrefix(name, m.path+"/") {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// children returns the names of the virtual entries of a directory, which lead to mount points
+func (f *Fs) children(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	for _, m := range f.mounts {
+		if !strings.HasPrefix(m.path, prefix) {
+			continue
+		}
+
+		name := strings.SplitN(m.path[len(prefix):], "/", 2)[0] //nolint:gomnd
+
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// isMountPoint checks if a path is a mount point or one of their parents
+func (f *Fs) isMountPoint(name string) bool {
+	for _, m := range f.mounts {
+		if name == m.path || strings.HasPrefix(m.path, strings.TrimSuffix(name, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// target returns the file system handling a path and the cleaned path
+func (f *Fs) target(op, name string) (afero.Fs, string, error) {
+	name = path.Clean("/" + name)
+
+	if m := f.route(name); m != nil {
+		return m.fs, name, nil
+	}
+
+	if f.root == nil {
+		if len(f.children(name)) > 0 {
+			return nil, name, &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+		}
+
+		return nil, name, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+
+	return f.root, name, nil
+}
+
+// Create creates a file in the file system handling its path
+func (f *Fs) Create(name string) (afero.File, error) {
+	fs, name, err := f.target("create", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Create(name)
+}
+
+// Mkdir creates a directory in the file system handling its path
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	fs, name, err := f.target("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return fs.Mkdir(name, perm)
+}
+
+// MkdirAll creates a directory path in the file system handling it
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	fs, name, err := f.target("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return fs.MkdirAll(name, perm)
+}
+
+// Open opens a file, merging the mount points into the directory listings
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file, merging the mount points into the directory listings
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	name = path.Clean("/" + name)
+
+	if m := f.route(name); m != nil {
+		return m.fs.OpenFile(name, flag, perm)
+	}
+
+	children := f.children(name)
+	if len(children) == 0 {
+		fs, _, err := f.target("open", name)
+		if err != nil {
+			return nil, err
+		}
+
+		return fs.OpenFile(name, flag, perm)
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	dir := &dirFile{name: name, children: children}
+
+	if f.root != nil {
+		if src, err := f.root.Open(name); err == nil {
+			dir.src = src
+		}
+	}
+
+	return dir, nil
+}
+
+// Remove removes a file, mount points can't be removed
+func (f *Fs) Remove(name string) error {
+	fs, name, err := f.target("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if f.isMountPoint(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrMountPoint}
+	}
+
+	return fs.Remove(name)
+}
+
+// RemoveAll removes a path, mount points can't be removed
+func (f *Fs) RemoveAll(name string) error {
+	fs, name, err := f.target("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if f.isMountPoint(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrMountPoint}
+	}
+
+	return fs.RemoveAll(name)
+}
+
+// Rename renames a file within a mount
+func (f *Fs) Rename(oldname, newname string) error {
+	oldFs, oldname, err := f.target("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	newFs, newname, err := f.target("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	if f.isMountPoint(oldname) || f.isMountPoint(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrMountPoint}
+	}
+
+	if oldFs != newFs {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrCrossMount}
+	}
+
+	return oldFs.Rename(oldname, newname)
+}
+
+// Stat returns the file info, the parent directories of the mount points are synthesized if needed
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	name = path.Clean("/" + name)
+
+	if m := f.route(name); m != nil {
+		info, err := m.fs.Stat(name)
+		if err != nil && name == m.path {
+			// Some backends can't stat their root
+			return &dirInfo{name: path.Base(name)}, nil
+		}
+
+		return info, err
+	}
+
+	virtual := len(f.children(name)) > 0
+
+	if f.root != nil {
+		if info, err := f.root.Stat(name); err == nil || !virtual {
+			return info, err
+		}
+	}
+
+	if virtual {
+		return &dirInfo{name: path.Base(name)}, nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// Name of the file system
+func (f *Fs) Name() string {
+	return "mount"
+}
+
+// Chmod changes the mode of a file in the file system handling its path
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	fs, name, err := f.target("chmod", name)
+	if err != nil {
+		return err
+	}
+
+	return fs.Chmod(name, mode)
+}
+
+// Chown changes the owner of a file in the file system handling its path
+func (f *Fs) Chown(name string, uid, gid int) error {
+	fs, name, err := f.target("chown", name)
+	if err != nil {
+		return err
+	}
+
+	return fs.Chown(name, uid, gid)
+}
+
+// Chtimes changes the times of a file in the file system handling its path
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	fs, name, err := f.target("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	return fs.Chtimes(name, atime, mtime)
+}
+
+// dirInfo describes a synthesized directory
+type dirInfo struct {
+	name string
+}
+
+func (i *dirInfo) Name() string       { return i.name }
+func (i *dirInfo) Size() int64        { return 0 }
+func (i *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0o755 }
+func (i *dirInfo) ModTime() time.Time { return time.Time{} }
+func (i *dirInfo) IsDir() bool        { return true }
+func (i *dirInfo) Sys() any           { return nil }
+
+// dirFile is a directory whose listing includes the mount points leading entries
+type dirFile struct {
+	name     string        // Name of the directory
+	src      afero.File    // Directory of the root file system, can be nil
+	children []string      // Names of the entries leading to mount points
+	entries  []os.FileInfo // Merged entries, loaded on the first Readdir call
+	offset   int           // Entries already returned
+}
+
+func (d *dirFile) load() error {
+	if d.entries != nil {
+		return nil
+	}
+
+	d.entries = make([]os.FileInfo, 0, len(d.children))
+	mounted := make(map[string]bool, len(d.children))
+
+	for _, child := range d.children {
+		mounted[child] = true
+		d.entries = append(d.entries, &dirInfo{name: child})
+	}
+
+	if d.src != nil {
+		infos, err := d.src.Readdir(-1)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			if !mounted[info.Name()] {
+				d.entries = append(d.entries, info)
+			}
+		}
+	}
+
+	sort.Slice(d.entries, func(i, j int) bool {
+		return d.entries[i].Name() < d.entries[j].Name()
+	})
+
+	return nil
+}
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	remaining := d.entries[d.offset:]
+
+	if count <= 0 {
+		d.offset = len(d.entries)
+
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+
+	d.offset += count
+
+	return remaining[:count], nil
+}
+
+func (d *dirFile) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+
+	return names, err
+}
+
+func (d *dirFile) Close() error {
+	if d.src != nil {
+		return d.src.Close()
+	}
+
+	return nil
+}
+
+func (d *dirFile) Name() string {
+	return d.name
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error) {
+	if d.src != nil {
+		return d.src.Stat()
+	}
+
+	return &dirInfo{name: path.Base(d.name)}, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) ReadAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) Seek(int64, int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) WriteString(string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) Sync() error {
+	return nil
+}
+
+func (d *dirFile) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: d.name, Err: syscall.EISDIR}
+}
@@ -0,0 +1,124 @@
+package mount
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func newMountFs(t *testing.T) (*Fs, afero.Fs, afero.Fs) {
+	t.Helper()
+
+	root, reports, inbox := afero.NewMemMapFs(), afero.NewMemMapFs(), afero.NewMemMapFs()
+
+	if err := afero.WriteFile(root, "/readme.txt", []byte("root"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	fs, err := NewFs(root, map[string]afero.Fs{
+		"/reports":    reports,
+		"/data/inbox": inbox,
+	})
+	if err != nil {
+		t.Fatalf("couldn't create fs: %v", err)
+	}
+
+	return fs, reports, inbox
+}
+
+func listDir(t *testing.T, fs afero.Fs, name string) []string {
+	t.Helper()
+
+	dir, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", name, err)
+	}
+
+	defer func() { _ = dir.Close() }()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("couldn't list %s: %v", name, err)
+	}
+
+	return names
+}
+
+func TestRouting(t *testing.T) {
+	fs, reports, inbox := newMountFs(t)
+
+	if err := afero.WriteFile(fs, "/reports/2024.csv", []byte("a,b"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if content, err := afero.ReadFile(reports, "/2024.csv"); err != nil || string(content) != "a,b" {
+		t.Fatalf("file wasn't written in the mount: %q, %v", content, err)
+	}
+
+	file, err := fs.Open("/reports/2024.csv")
+	if err != nil {
+		t.Fatalf("couldn't open file: %v", err)
+	}
+
+	if file.Name() != "/reports/2024.csv" {
+		t.Fatalf("unexpected file name: %s", file.Name())
+	}
+
+	_ = file.Close()
+
+	if err := fs.Mkdir("/data/inbox/new", 0750); err != nil {
+		t.Fatalf("couldn't create dir: %v", err)
+	}
+
+	if info, err := inbox.Stat("/new"); err != nil || !info.IsDir() {
+		t.Fatalf("dir wasn't created in the mount: %v", err)
+	}
+}
+
+func TestListing(t *testing.T) {
+	fs, _, _ := newMountFs(t)
+
+	if names := listDir(t, fs, "/"); !reflect.DeepEqual(names, []string{"data", "readme.txt", "reports"}) {
+		t.Fatalf("unexpected root listing: %v", names)
+	}
+
+	if names := listDir(t, fs, "/data"); !reflect.DeepEqual(names, []string{"inbox"}) {
+		t.Fatalf("unexpected virtual dir listing: %v", names)
+	}
+
+	if info, err := fs.Stat("/data"); err != nil || !info.IsDir() {
+		t.Fatalf("virtual dir should be a directory: %v", err)
+	}
+}
+
+func TestChangingMounts(t *testing.T) {
+	fs, reports, _ := newMountFs(t)
+
+	if err := afero.WriteFile(reports, "/a.csv", []byte("a"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	if err := fs.Rename("/reports/a.csv", "/data/inbox/a.csv"); !errors.Is(err, ErrCrossMount) {
+		t.Fatalf("expected ErrCrossMount, got: %v", err)
+	}
+
+	if err := fs.Rename("/reports/a.csv", "/reports/b.csv"); err != nil {
+		t.Fatalf("rename within a mount failed: %v", err)
+	}
+
+	for _, name := range []string{"/reports", "/data"} {
+		if err := fs.RemoveAll(name); !errors.Is(err, ErrMountPoint) {
+			t.Fatalf("expected ErrMountPoint for %s, got: %v", name, err)
+		}
+	}
+}
+
+func TestInvalidMountPath(t *testing.T) {
+	for _, name := range []string{"/", "relative", "/a/../b", "/a/"} {
+		if _, err := NewFs(nil, map[string]afero.Fs{name: afero.NewMemMapFs()}); !errors.Is(err, ErrInvalidMountPath) {
+			t.Fatalf("expected ErrInvalidMountPath for %s, got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package quota provides an afero FS wrapper enforcing storage quotas
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	ftpserver "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// ErrQuotaExceeded is returned when an operation would exceed the quota. It is reported as an FTP 552 error.
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded: %w", ftpserver.ErrStorageExceeded)
+
+// ErrNoBytesLimit is returned when asking for the available space of a quota without any bytes limit
+var ErrNoBytesLimit = errors.New("no bytes limit")
+
+// Usage tracks the storage used by an access
+type Usage struct {
+	sync.Mutex
+	Bytes     int64  `json:"bytes"` // Bytes used
+	Files     int64  `json:"files"` // Files stored
+	stateFile string // File where the usage is persisted
+}
+
+// Usages keeps the usage of each access so that it is shared between sessions
+type Usages struct {
+	sync.Mutex
+	usages map[string]*Usage
+}
+
+// Fs is a wrapper to enforce a quota on a file system
+type Fs struct {
+	afero.Fs        // Source file system
+	usage    *Usage // Associated usage
+	maxBytes int64  // Maximum bytes that can be stored
+	maxFiles int64  // Maximum files that can be stored
+}
+
+// File is a wrapper to account the bytes written to a file
+type File struct {
+	afero.File       // Source file
+	fs         *Fs   // Associated file system
+	size       int64 // Current size of the file
+	pos        int64 // Current position in the file
+}
+
+// NewUsages creates a usages registry
+func NewUsages() *Usages {
+	return &Usages{
+		usages: make(map[string]*Usage),
+	}
+}
+
+// Get returns the usage associated with a name, loading it from its state file or by scanning the
+// file system the first time.
+func (u *Usages) Get(name string, fs afero.Fs, stateFile string) (*Usage, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if usage := u.usages[name]; usage != nil {
+		return usage, nil
+	}
+
+	usage, err := loadUsage(fs, stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	u.usages[name] = usage
+
+	return usage, nil
+}
+
+func loadUsage(fs afero.Fs, stateFile string) (*Usage, error) {
+	usage := &Usage{stateFile: stateFile}
+
+	if stateFile != "" {
+		content, err := os.ReadFile(filepath.Clean(stateFile))
+
+		switch {
+		case err == nil:
+			if errUnmarshal := json.Unmarshal(content, usage); errUnmarshal != nil {
+				return nil, fmt.Errorf("could not parse quota state file %s: %w", stateFile, errUnmarshal)
+			}
+
+			return usage, nil
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("could not read quota state file %s: %w", stateFile, err)
+		}
+	}
+
+	errWalk := afero.Walk(fs, "/", func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			usage.Bytes += info.Size()
+			usage.Files++
+		}
+
+		return nil
+	})
+	if errWalk != nil {
+		return nil, fmt.Errorf("could not compute quota usage: %w", errWalk)
+	}
+
+	return usage, usage.Save()
+}
+
+// Get returns the bytes and files currently used
+func (u *Usage) Get() (int64, int64) {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.Bytes, u.Files
+}
+
+// Save persists the usage to its state file, if any
+func (u *Usage) Save() error {
+	if u.stateFile == "" {
+		return nil
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	content, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	// Writing to a temporary file first avoids ending up with a truncated state
+	tmpFile := u.stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil { //nolint:gomnd
+		return err
+	}
+
+	return os.Rename(tmpFile, u.stateFile)
+}
+
+// reserve adds some usage if it stays within the limits
+func (u *Usage) reserve(bytes, files, maxBytes, maxFiles int64) error {
+	u.Lock()
+	defer u.Unlock()
+
+	if maxBytes > 0 && bytes > 0 && u.Bytes+bytes > maxBytes {
+		return fmt.Errorf("%w: %d bytes used out of %d", ErrQuotaExceeded, u.Bytes, maxBytes)
+	}
+
+	if maxFiles > 0 && files > 0 && u.Files+files > maxFiles {
+		return fmt.Errorf("%w: %d files stored out of %d", ErrQuotaExceeded, u.Files, maxFiles)
+	}
+
+	u.Bytes += bytes
+	u.Files += files
+
+	return nil
+}
+
+// add changes the usage without checking the limits
+func (u *Usage) add(bytes, files int64) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.Bytes += bytes
+	u.Files += files
+}
+
+// LoadFS creates an instance enforcing the quota
+func LoadFS(src afero.Fs, usage *Usage, quota *confpar.Quota) (*Fs, error) {
+	return &Fs{
+		Fs:       src,
+		usage:    usage,
+		maxBytes: quota.MaxBytes,
+		maxFiles: quota.MaxFiles,
+	}, nil
+}
+
+// GetAvailableSpace returns the number of bytes that can still be stored
+func (f *Fs) GetAvailableSpace(_ string) (int64, error) {
+	if f.maxBytes <= 0 {
+		return 0, ErrNoBytesLimit
+	}
+
+	bytes, _ := f.usage.Get()
+
+	if bytes >= f.maxBytes {
+		return 0, nil
+	}
+
+	return f.maxBytes - bytes, nil
+}
+
+// Create creates a file if the files quota allows it
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666) //nolint:gomnd
+}
+
+// OpenFile opens a file, accounting for the created or truncated files
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f.Fs.OpenFile(name, flag, perm)
+	}
+
+	var size int64
+
+	info, errStat := f.Fs.Stat(name)
+	exists := errStat == nil
+
+	if exists {
+		size = info.Size()
+	} else if flag&os.O_CREATE != 0 {
+		if err := f.usage.reserve(0, 1, f.maxBytes, f.maxFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	src, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		if !exists && flag&os.O_CREATE != 0 {
+			f.usage.add(0, -1)
+		}
+
+		return nil, err
+	}
+
+	if exists && flag&os.O_TRUNC != 0 {
+		f.usage.add(-size, 0)
+		size = 0
+	}
+
+	file := &File{File: src, fs: f, size: size}
+
+	if flag&os.O_APPEND != 0 {
+		file.pos = size
+	}
+
+	return file, nil
+}
+
+// Remove removes a file and releases its usage
+func (f *Fs) Remove(name string) error {
+	info, errStat := f.Fs.Stat(name)
+
+	if err := f.Fs.Remove(name); err != nil {
+		return err
+	}
+
+	if errStat == nil && !info.IsDir() {
+		f.usage.add(-info.Size(), -1)
+	}
+
+	return f.usage.Save()
+}
+
+// SymlinkIfPossible creates a symbolic link if the files quota allows it
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	if err := f.usage.reserve(0, 1, f.maxBytes, f.maxFiles); err != nil {
+		return err
+	}
+
+	if err := utils.Symlink(f.Fs, oldname, newname); err != nil {
+		f.usage.add(0, -1)
+
+		return err
+	}
+
+	return f.usage.Save()
+}
+
+// ReadlinkIfPossible returns the target of a symbolic link on the source file system
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.Fs, name)
+}
+
+// LstatIfPossible returns the info of a file without following symbolic links
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.Fs, name)
+}
+
+// RemoveAll removes a path and releases the usage of all the files it contains
+func (f *Fs) RemoveAll(path string) error {
+	var bytes, files int64
+
+	_ = afero.Walk(f.Fs, path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			bytes += info.Size()
+			files++
+		}
+
+		return nil
+	})
+
+	if err := f.Fs.RemoveAll(path); err != nil {
+		return err
+	}
+
+	f.usage.add(-bytes, -files)
+
+	return f.usage.Save()
+}
+
+// Rename renames a file, releasing the usage of the file it might replace
+func (f *Fs) Rename(oldname, newname string) error {
+	info, errStat := f.Fs.Stat(newname)
+
+	if err := f.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	if errStat == nil && !info.IsDir() {
+		f.usage.add(-info.Size(), -1)
+	}
+
+	return f.usage.Save()
+}
+
+// grow reserves the bytes needed to write length bytes at a given offset
+func (f *File) grow(offset int64, length int) (int64, error) {
+	growth := offset + int64(length) - f.size
+	if growth <= 0 {
+		return 0, nil
+	}
+
+	return growth, f.fs.usage.reserve(growth, 0, f.fs.maxBytes, f.fs.maxFiles)
+}
+
+// written updates the file size after a write and releases what was reserved but not written
+func (f *File) written(offset int64, n int, growth int64) {
+	end := offset + int64(n)
+	actualGrowth := end - f.size
+
+	if actualGrowth < 0 {
+		actualGrowth = 0
+	}
+
+	if actualGrowth < growth {
+		f.fs.usage.add(actualGrowth-growth, 0)
+	}
+
+	if end > f.size {
+		f.size = end
+	}
+}
+
+// Write writes to the file if the bytes quota allows it
+func (f *File) Write(p []byte) (int, error) {
+	growth, err := f.grow(f.pos, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(p)
+	f.written(f.pos, n, growth)
+	f.pos += int64(n)
+
+	return n, err
+}
+
+// WriteAt writes to the file if the bytes quota allows it
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	growth, err := f.grow(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.WriteAt(p, off)
+	f.written(off, n, growth)
+
+	return n, err
+}
+
+// WriteString writes to the file if the bytes quota allows it
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Read reads from the file, keeping track of the position
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+
+	return n, err
+}
+
+// Seek moves within the file, keeping track of the position
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+
+	return pos, err
+}
+
+// Truncate changes the size of the file if the bytes quota allows it
+func (f *File) Truncate(size int64) error {
+	delta := size - f.size
+
+	if delta > 0 {
+		if err := f.fs.usage.reserve(delta, 0, f.fs.maxBytes, f.fs.maxFiles); err != nil {
+			return err
+		}
+	}
+
+	if err := f.File.Truncate(size); err != nil {
+		if delta > 0 {
+			f.fs.usage.add(-delta, 0)
+		}
+
+		return err
+	}
+
+	if delta < 0 {
+		f.fs.usage.add(delta, 0)
+	}
+
+	f.size = size
+
+	return nil
+}
+
+// Close closes the file and persists the usage
+func (f *File) Close() error {
+	err := f.File.Close()
+
+	if errSave := f.fs.usage.Save(); err == nil {
+		err = errSave
+	}
+
+	return err
+}
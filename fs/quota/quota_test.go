@@ -0,0 +1,102 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	ftpserver "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func newQuotaFs(t *testing.T, src afero.Fs, usages *Usages, quota *confpar.Quota) *Fs {
+	t.Helper()
+
+	usage, err := usages.Get("test", src, quota.StateFile)
+	if err != nil {
+		t.Fatalf("couldn't load usage: %v", err)
+	}
+
+	fs, err := LoadFS(src, usage, quota)
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs
+}
+
+func writeFile(fs afero.Fs, name string, size int) error {
+	file, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, errWrite := file.Write(make([]byte, size))
+
+	if errClose := file.Close(); errWrite == nil {
+		errWrite = errClose
+	}
+
+	return errWrite
+}
+
+func TestBytesQuota(t *testing.T) {
+	src := afero.NewMemMapFs()
+	if err := afero.WriteFile(src, "/existing.bin", make([]byte, 400), 0600); err != nil {
+		t.Fatalf("couldn't write file: %v", err)
+	}
+
+	fs := newQuotaFs(t, src, NewUsages(), &confpar.Quota{MaxBytes: 1000})
+
+	if available, _ := fs.GetAvailableSpace("/"); available != 600 {
+		t.Fatalf("existing files weren't accounted: %d available", available)
+	}
+
+	if err := writeFile(fs, "/a.bin", 500); err != nil {
+		t.Fatalf("write within the quota failed: %v", err)
+	}
+
+	if err := writeFile(fs, "/b.bin", 200); !errors.Is(err, ftpserver.ErrStorageExceeded) {
+		t.Fatalf("expected ErrStorageExceeded, got: %v", err)
+	}
+
+	// Overwriting a file releases its previous content
+	if err := writeFile(fs, "/a.bin", 600); err != nil {
+		t.Fatalf("overwrite within the quota failed: %v", err)
+	}
+
+	if err := fs.Remove("/existing.bin"); err != nil {
+		t.Fatalf("couldn't remove file: %v", err)
+	}
+
+	if available, _ := fs.GetAvailableSpace("/"); available != 400 {
+		t.Fatalf("unexpected available space: %d", available)
+	}
+}
+
+func TestFilesQuotaAndState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	quota := &confpar.Quota{MaxFiles: 2, StateFile: stateFile}
+	src := afero.NewMemMapFs()
+
+	fs := newQuotaFs(t, src, NewUsages(), quota)
+
+	for _, name := range []string{"/a", "/b"} {
+		if err := writeFile(fs, name, 10); err != nil {
+			t.Fatalf("couldn't write %s: %v", name, err)
+		}
+	}
+
+	if err := writeFile(fs, "/c", 10); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got: %v", err)
+	}
+
+	// The usage is read back from the state file, not from the file system
+	fs = newQuotaFs(t, afero.NewMemMapFs(), NewUsages(), quota)
+
+	if bytes, files := fs.usage.Get(); bytes != 20 || files != 2 {
+		t.Fatalf("usage wasn't persisted: %d bytes, %d files", bytes, files)
+	}
+}
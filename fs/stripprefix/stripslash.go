@@ -4,6 +4,7 @@ package stripprefix
 import (
 	"errors"
 	"os"
+	"path"
 	"time"
 
 	"github.com/spf13/afero"
@@ -21,7 +22,7 @@ type Fs struct {
 // File is the afero.File implementation
 type File struct {
 	afero.File
-	start int
+	prefix string
 }
 
 // NewStripPrefixFs is an internal FS implementation to remove a path prefix
@@ -30,18 +31,21 @@ func NewStripPrefixFs(source afero.Fs, start int) afero.Fs {
 	return &Fs{source: source, start: start}
 }
 
-// Name of the file
+// Name of the file, with the stripped prefix
 func (f *File) Name() string {
-	return f.File.Name()[f.start:]
+	return path.Join(f.prefix, f.File.Name())
 }
 
-// on a afero.File outside the base path it returns the given afero.File name and an error,
-// else the given afero.File with the base path prepended
-func (b *Fs) realPath(name string) (path string, err error) {
+// on a path shorter than the prefix it returns an error, else the path without its prefix
+func (b *Fs) realPath(name string) (string, error) {
 	if len(name) < b.start {
 		return "", ErrBasePathTooShort
 	}
 
+	if len(name) == b.start {
+		return "/", nil
+	}
+
 	return name[b.start:], nil
 }
 
@@ -121,6 +125,8 @@ func (b *Fs) Remove(name string) (err error) {
 
 // OpenFile opens a file using the given flags and the given mode.
 func (b *Fs) OpenFile(name string, flag int, mode os.FileMode) (f afero.File, err error) {
+	prefix := name[:min(len(name), b.start)]
+
 	if name, err = b.realPath(name); err != nil {
 		return nil, &os.PathError{Op: "openfile", Path: name, Err: err}
 	}
@@ -131,11 +137,13 @@ func (b *Fs) OpenFile(name string, flag int, mode os.FileMode) (f afero.File, er
 		return nil, err
 	}
 
-	return &File{File: sourcef, start: b.start}, nil
+	return &File{File: sourcef, prefix: prefix}, nil
 }
 
 // Open opens a file, returning it or an error, if any happens.
 func (b *Fs) Open(name string) (f afero.File, err error) {
+	prefix := name[:min(len(name), b.start)]
+
 	if name, err = b.realPath(name); err != nil {
 		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
@@ -146,7 +154,7 @@ func (b *Fs) Open(name string) (f afero.File, err error) {
 		return nil, err
 	}
 
-	return &File{File: sourcef, start: b.start}, nil
+	return &File{File: sourcef, prefix: prefix}, nil
 }
 
 // Mkdir creates a directory in the filesystem, return an error if any
@@ -172,6 +180,8 @@ func (b *Fs) MkdirAll(name string, mode os.FileMode) (err error) {
 // Create creates a file in the filesystem, returning the file and an
 // error, if any happens.
 func (b *Fs) Create(name string) (f afero.File, err error) {
+	prefix := name[:min(len(name), b.start)]
+
 	if name, err = b.realPath(name); err != nil {
 		return nil, &os.PathError{Op: "create", Path: name, Err: err}
 	}
@@ -182,7 +192,7 @@ func (b *Fs) Create(name string) (f afero.File, err error) {
 		return nil, err
 	}
 
-	return &File{File: sourcef, start: b.start}, nil
+	return &File{File: sourcef, prefix: prefix}, nil
 }
 
 // LstatIfPossible implements afero.Lstater.LstatIfPossible
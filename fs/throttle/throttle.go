@@ -0,0 +1,243 @@
+// Package throttle provides an afero FS wrapper limiting the bandwidth of file transfers
+package throttle
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/spf13/afero"
+	"golang.org/x/time/rate"
+
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// Fs is a wrapper to throttle the files opened on a file system
+type Fs struct {
+	afero.Fs                 // Source file system
+	ctx      context.Context // Session context, interrupting the waits when it ends
+	download []*rate.Limiter // Limiters applied on reads
+	upload   []*rate.Limiter // Limiters applied on writes
+}
+
+// File is a wrapper to throttle reads and writes
+type File struct {
+	afero.File                    // Source file
+	ctx        context.Context    // Cancelled when the file is closed
+	cancel     context.CancelFunc // Interrupts the waits
+	download   []*rate.Limiter    // Limiters applied on reads
+	upload     []*rate.Limiter    // Limiters applied on writes
+}
+
+// Limiters keeps the limiters by name so that they can be shared between sessions
+type Limiters struct {
+	sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewLimiters creates a limiters registry
+func NewLimiters() *Limiters {
+	return &Limiters{
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Get returns the limiter associated with a name, creating it or updating its rate if needed.
+// A nil limiter is returned when bytesPerSecond is not positive.
+func (l *Limiters) Get(name string, bytesPerSecond int) *rate.Limiter {
+	l.Lock()
+	defer l.Unlock()
+
+	if bytesPerSecond <= 0 {
+		delete(l.limiters, name)
+
+		return nil
+	}
+
+	limiter := l.limiters[name]
+
+	if limiter == nil {
+		// We allow a burst of one second of transfer
+		limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+		l.limiters[name] = limiter
+	} else if limiter.Burst() != bytesPerSecond {
+		limiter.SetLimit(rate.Limit(bytesPerSecond))
+		limiter.SetBurst(bytesPerSecond)
+	}
+
+	return limiter
+}
+
+// LoadFS creates a throttled instance. The source is returned as-is if there is no limiter. The waits for the
+// limiters are interrupted when ctx is done.
+func LoadFS(ctx context.Context, src afero.Fs, download, upload []*rate.Limiter) (afero.Fs, error) {
+	download, upload = compact(download), compact(upload)
+
+	if len(download) == 0 && len(upload) == 0 {
+		return src, nil
+	}
+
+	return &Fs{
+		Fs:       src,
+		ctx:      ctx,
+		download: download,
+		upload:   upload,
+	}, nil
+}
+
+func compact(limiters []*rate.Limiter) []*rate.Limiter {
+	list := make([]*rate.Limiter, 0, len(limiters))
+
+	for _, l := range limiters {
+		if l != nil {
+			list = append(list, l)
+		}
+	}
+
+	return list
+}
+
+func (f *Fs) wrap(src afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(f.ctx)
+
+	return &File{
+		File:     src,
+		ctx:      ctx,
+		cancel:   cancel,
+		download: f.download,
+		upload:   f.upload,
+	}, nil
+}
+
+// Create creates a throttled file
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Create(name))
+}
+
+// Open opens a throttled file
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Open(name))
+}
+
+// OpenFile opens a throttled file
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return f.wrap(f.Fs.OpenFile(name, flag, perm))
+}
+
+// SymlinkIfPossible creates a symbolic link on the source file system
+func (f *Fs) SymlinkIfPossible(oldname, newname string) error {
+	return utils.Symlink(f.Fs, oldname, newname)
+}
+
+// ReadlinkIfPossible returns the target of a symbolic link on the source file system
+func (f *Fs) ReadlinkIfPossible(name string) (string, error) {
+	return utils.Readlink(f.Fs, name)
+}
+
+// LstatIfPossible returns the info of a file without following symbolic links
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return utils.Lstat(f.Fs, name)
+}
+
+// maxChunk returns the biggest amount of bytes that can be transferred at once
+func maxChunk(limiters []*rate.Limiter, size int) int {
+	for _, l := range limiters {
+		if burst := l.Burst(); burst < size {
+			size = burst
+		}
+	}
+
+	return size
+}
+
+func wait(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read reads at most one burst of data and waits for the limiters to allow it
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p[:maxChunk(f.download, len(p))])
+
+	if errWait := wait(f.ctx, f.download, n); errWait != nil && err == nil {
+		err = errWait
+	}
+
+	return n, err
+}
+
+// ReadAt reads at most one burst of data and waits for the limiters to allow it
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p[:maxChunk(f.download, len(p))], off)
+
+	if errWait := wait(f.ctx, f.download, n); errWait != nil && err == nil {
+		err = errWait
+	}
+
+	return n, err
+}
+
+// Close closes the file, interrupting the transfers waiting for the limiters
+func (f *File) Close() error {
+	f.cancel()
+
+	return f.File.Close()
+}
+
+// Write writes the data by chunks allowed by the limiters
+func (f *File) Write(p []byte) (int, error) {
+	written := 0
+
+	for written < len(p) {
+		chunk := p[written : written+maxChunk(f.upload, len(p)-written)]
+
+		if err := wait(f.ctx, f.upload, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := f.File.Write(chunk)
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// WriteAt writes the data by chunks allowed by the limiters
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+
+	for written < len(p) {
+		chunk := p[written : written+maxChunk(f.upload, len(p)-written)]
+
+		if err := wait(f.ctx, f.upload, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := f.File.WriteAt(chunk, off+int64(written))
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// WriteString writes the string by chunks allowed by the limiters
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
@@ -0,0 +1,107 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/time/rate"
+)
+
+func TestThrottledWrite(t *testing.T) {
+	limiters := NewLimiters()
+
+	fs, err := LoadFS(context.Background(), afero.NewMemMapFs(), nil, []*rate.Limiter{limiters.Get("upload", 50000)})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	file, err := fs.Create("/file.bin")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	start := time.Now()
+
+	// The first 50000 bytes are allowed by the burst, the rest takes 0.5s
+	if n, errWrite := file.Write(make([]byte, 75000)); errWrite != nil || n != 75000 {
+		t.Fatalf("couldn't write file: %d, %v", n, errWrite)
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("write wasn't throttled: %v", elapsed)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close file: %v", err)
+	}
+}
+
+func TestInterruptedWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Writing 100 times the burst would take 99s
+	fs, err := LoadFS(ctx, afero.NewMemMapFs(), nil, []*rate.Limiter{rate.NewLimiter(1000, 1000)})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	// The session is only ended once the closing is checked
+	for _, tc := range []struct {
+		name      string
+		interrupt func(file afero.File)
+	}{
+		{"close", func(file afero.File) { _ = file.Close() }},
+		{"session", func(afero.File) { cancel() }},
+	} {
+		file, err := fs.Create("/" + tc.name)
+		if err != nil {
+			t.Fatalf("couldn't create file: %v", err)
+		}
+
+		done := make(chan error, 1)
+
+		go func() {
+			_, errWrite := file.Write(make([]byte, 100000))
+			done <- errWrite
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		tc.interrupt(file)
+
+		select {
+		case errWrite := <-done:
+			if errWrite == nil {
+				t.Fatalf("%s: the write should fail", tc.name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the wait wasn't interrupted", tc.name)
+		}
+
+		_ = file.Close()
+	}
+}
+
+func TestLimitersShared(t *testing.T) {
+	limiters := NewLimiters()
+
+	first := limiters.Get("download:test", 1000)
+	if second := limiters.Get("download:test", 2000); first != second {
+		t.Fatal("limiters with the same name should be shared")
+	}
+
+	if first.Burst() != 2000 {
+		t.Fatalf("limiter rate wasn't updated: %d", first.Burst())
+	}
+
+	if limiters.Get("download:test", 0) != nil {
+		t.Fatal("a zero rate shouldn't create any limiter")
+	}
+
+	fs := afero.NewMemMapFs()
+	if wrapped, _ := LoadFS(context.Background(), fs, []*rate.Limiter{nil}, nil); wrapped != fs {
+		t.Fatal("a fs without limiters shouldn't be wrapped")
+	}
+}
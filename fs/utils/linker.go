@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// Symlink creates a symbolic link on a file system, if it supports them. File system wrappers use it to keep
+// the afero.Linker interface of the file system they wrap.
+func Symlink(fs afero.Fs, oldname, newname string) error {
+	if linker, ok := fs.(afero.Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+}
+
+// Readlink returns the target of a symbolic link on a file system, if it supports them
+func Readlink(fs afero.Fs, name string) (string, error) {
+	if reader, ok := fs.(afero.LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+}
+
+// Lstat returns the info of a file without following symbolic links, if the file system supports it
+func Lstat(fs afero.Fs, name string) (os.FileInfo, bool, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+
+	info, err := fs.Stat(name)
+
+	return info, false, err
+}
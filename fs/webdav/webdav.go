@@ -0,0 +1,459 @@
+// Package webdav provides a WebDAV client access layer
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// DefaultTimeout is the maximum time of the metadata requests, transfers aren't limited
+const DefaultTimeout = 30 * time.Second
+
+// ErrNoURL is returned when the url parameter is missing
+var ErrNoURL = errors.New("url parameter is required for webdav")
+
+// ErrNotSupported is returned for the operations WebDAV can't do, like appending or random writes
+var ErrNotSupported = errors.New("not supported by webdav")
+
+// ErrUnexpectedStatus is returned when the server answers with an unexpected status code
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// propfindBody requests the properties used to describe the files
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+// Fs is a WebDAV share exposed as a file system
+type Fs struct {
+	client   *http.Client
+	base     *url.URL // URL of the root directory, without trailing slash
+	user     string   // Basic authentication user
+	password string   // Basic authentication password
+	token    string   // Bearer token
+	timeout  time.Duration
+}
+
+// LoadFs loads a file system from an access description
+func LoadFs(access *confpar.Access) (afero.Fs, error) {
+	par := access.Params
+
+	if par["url"] == "" {
+		return nil, ErrNoURL
+	}
+
+	base, err := url.Parse(par["url"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+
+	base.Path = strings.TrimSuffix(path.Join("/", base.Path, par["basePath"]), "/")
+
+	timeout := DefaultTimeout
+	if value := par["timeout"]; value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid webdav timeout %q: %w", value, err)
+		}
+	}
+
+	return &Fs{
+		client:   &http.Client{},
+		base:     base,
+		user:     par["user"],
+		password: par["password"],
+		token:    par["token"],
+		timeout:  timeout,
+	}, nil
+}
+
+// Name of the file system
+func (fs *Fs) Name() string {
+	return "webdav"
+}
+
+// url returns the URL of a path, directories get a trailing slash
+func (fs *Fs) url(name string, dir bool) string {
+	u := *fs.base
+	u.Path = path.Join(u.Path, path.Clean("/"+name))
+
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u.String()
+}
+
+// request sends a request, its context lasts until the response body is closed
+func (fs *Fs) request(
+	ctx context.Context, method, target string, body io.Reader, header map[string]string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fs.token != "":
+		req.Header.Set("Authorization", "Bearer "+fs.token)
+	case fs.user != "":
+		req.SetBasicAuth(fs.user, fs.password)
+	}
+
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	return fs.client.Do(req)
+}
+
+// do sends a metadata request, and checks its status code
+func (fs *Fs) do(op, name, method string, dir bool, body io.Reader, header map[string]string, expected ...int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fs.timeout)
+	defer cancel()
+
+	resp, err := fs.request(ctx, method, fs.url(name, dir), body, header)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return checkStatus(op, name, resp, expected...)
+}
+
+// checkStatus converts an unexpected status code to an error
+func checkStatus(op, name string, resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusConflict:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	default:
+		return &os.PathError{Op: op, Path: name, Err: fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)}
+	}
+}
+
+// Create creates a file
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// Mkdir creates a directory
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fs.timeout)
+	defer cancel()
+
+	resp, err := fs.request(ctx, "MKCOL", fs.url(name, true), nil, nil)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		// MKCOL isn't allowed on an existing resource
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	return checkStatus("mkdir", name, resp, http.StatusCreated)
+}
+
+// MkdirAll creates a directory and all its missing parents
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	current := "/"
+
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		current = path.Join(current, part)
+
+		info, err := fs.Stat(current)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+
+			continue
+		}
+
+		if err := fs.Mkdir(current, perm); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open opens a file or a directory for reading
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file for reading, or creates it for writing
+func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &File{fs: fs, name: name, info: info}, nil
+	}
+
+	if flag&(os.O_APPEND|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+
+	if info, err := fs.Stat(name); err == nil && info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	return fs.newWriter(name), nil
+}
+
+// newWriter starts the streaming upload of a file, with a chunked PUT request
+func (fs *Fs) newWriter(name string) *File {
+	reader, writer := io.Pipe()
+	file := &File{fs: fs, name: name, writer: writer, uploaded: make(chan error, 1)}
+
+	go func() {
+		resp, err := fs.request(context.Background(), http.MethodPut, fs.url(name, false), reader, nil)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			err = checkStatus("write", name, resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		}
+
+		// Unblocks the writes when the upload failed
+		_ = reader.CloseWithError(err)
+		file.uploaded <- err
+	}()
+
+	return file
+}
+
+// get downloads a file from an offset, a count of 0 means up to the end
+func (fs *Fs) get(name string, offset, count int64) (io.ReadCloser, error) {
+	header := map[string]string{}
+
+	switch {
+	case count > 0:
+		header["Range"] = fmt.Sprintf("bytes=%d-%d", offset, offset+count-1)
+	case offset > 0:
+		header["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+
+	resp, err := fs.request(context.Background(), http.MethodGet, fs.url(name, false), nil, header)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	if err := checkStatus("read", name, resp, http.StatusOK, http.StatusPartialContent); err != nil {
+		_ = resp.Body.Close()
+
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		// The server ignored the range, the beginning of the file is skipped
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			_ = resp.Body.Close()
+
+			return nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+	}
+
+	return resp.Body, nil
+}
+
+// Remove removes a file or an empty directory
+func (fs *Fs) Remove(name string) error {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := fs.readDir(name)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	return fs.do("remove", name, http.MethodDelete, info.IsDir(), nil, nil, http.StatusOK, http.StatusNoContent)
+}
+
+// RemoveAll removes a file or a directory and all its content
+func (fs *Fs) RemoveAll(name string) error {
+	err := fs.do("remove", name, http.MethodDelete, false, nil, nil, http.StatusOK, http.StatusNoContent)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Rename moves a file or a directory
+func (fs *Fs) Rename(oldname, newname string) error {
+	return fs.do("rename", oldname, "MOVE", false, nil, map[string]string{
+		"Destination": fs.url(newname, false),
+		"Overwrite":   "T",
+	}, http.StatusCreated, http.StatusNoContent)
+}
+
+// Stat returns the info of a file or a directory
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	infos, err := fs.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(infos) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	info := infos[0]
+	info.name = path.Base(path.Clean("/" + name))
+
+	return info, nil
+}
+
+// readDir returns the files and directories directly under a directory
+func (fs *Fs) readDir(name string) ([]os.FileInfo, error) {
+	infos, err := fs.propfind(name, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	self := strings.TrimSuffix(path.Join(fs.base.Path, path.Clean("/"+name)), "/")
+	entries := make([]os.FileInfo, 0, len(infos))
+
+	for _, info := range infos {
+		if info.href == self {
+			continue
+		}
+
+		entries = append(entries, info)
+	}
+
+	return entries, nil
+}
+
+// multistatus is the response of a PROPFIND request
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind returns the info of a resource (depth 0) or of a directory and its entries (depth 1)
+func (fs *Fs) propfind(name, depth string) ([]*fileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fs.timeout)
+	defer cancel()
+
+	resp, err := fs.request(ctx, "PROPFIND", fs.url(name, false), strings.NewReader(propfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := checkStatus("stat", name, resp, http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+
+	var status multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: fmt.Errorf("invalid PROPFIND response: %w", err)}
+	}
+
+	infos := make([]*fileInfo, 0, len(status.Responses))
+
+	for _, r := range status.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+
+		info := &fileInfo{href: strings.TrimSuffix(href.Path, "/")}
+		info.name = path.Base(info.href)
+
+		for _, propstat := range r.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+
+			prop := propstat.Prop
+			info.dir = info.dir || prop.ResourceType.Collection != nil
+
+			if size, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+				info.size = size
+			}
+
+			if modTime, err := http.ParseTime(prop.LastModified); err == nil {
+				info.modTime = modTime
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// Chmod is not supported by WebDAV, and ignored
+func (fs *Fs) Chmod(string, os.FileMode) error {
+	return nil
+}
+
+// Chown is not supported by WebDAV, and ignored
+func (fs *Fs) Chown(string, int, int) error {
+	return nil
+}
+
+// Chtimes is not supported by WebDAV, and ignored
+func (fs *Fs) Chtimes(string, time.Time, time.Time) error {
+	return nil
+}
@@ -0,0 +1,234 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/webdav"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/fstest"
+)
+
+// testServer is an in-process WebDAV server, serving a memory file system under /dav
+type testServer struct {
+	*httptest.Server
+	fs       *notifyingFs
+	mu       sync.Mutex
+	requests []*http.Request
+	auth     func(r *http.Request) bool
+}
+
+func newTestServer(t *testing.T, auth func(r *http.Request) bool) *testServer {
+	t.Helper()
+
+	srv := &testServer{
+		fs:   &notifyingFs{FileSystem: webdav.NewMemFS(), written: make(chan struct{}, 1)},
+		auth: auth,
+	}
+	handler := &webdav.Handler{Prefix: "/dav", FileSystem: srv.fs, LockSystem: webdav.NewMemLS()}
+
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r)
+		srv.mu.Unlock()
+
+		if !srv.auth(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func (s *testServer) methodRequests(method string) []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []*http.Request
+
+	for _, r := range s.requests {
+		if r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+
+	return requests
+}
+
+// notifyingFs signals the first write received by the server
+type notifyingFs struct {
+	webdav.FileSystem
+	written chan struct{}
+}
+
+func (fs *notifyingFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file, err
+	}
+
+	return &notifyingFile{File: file, written: fs.written}, nil
+}
+
+type notifyingFile struct {
+	webdav.File
+	written chan struct{}
+}
+
+func (f *notifyingFile) Write(p []byte) (int, error) {
+	select {
+	case f.written <- struct{}{}:
+	default:
+	}
+
+	return f.File.Write(p)
+}
+
+func basicAuth(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+
+	return ok && user == "alice" && pass == "secret"
+}
+
+// loadFs loads a file system on the server, authenticated as alice unless a token is given
+func (s *testServer) loadFs(t *testing.T, params map[string]string) afero.Fs {
+	t.Helper()
+
+	if params["url"] == "" {
+		params["url"] = s.URL + "/dav"
+	}
+
+	if params["token"] == "" {
+		params["user"] = "alice"
+		params["password"] = "secret"
+	}
+
+	fs, err := LoadFs(&confpar.Access{Fs: "webdav", Params: params})
+	if err != nil {
+		t.Fatalf("couldn't load fs: %v", err)
+	}
+
+	return fs
+}
+
+func TestStreamingUpload(t *testing.T) {
+	srv := newTestServer(t, basicAuth)
+
+	if err := srv.loadFs(t, map[string]string{}).MkdirAll("/users/alice", 0o755); err != nil {
+		t.Fatalf("couldn't create base path: %v", err)
+	}
+
+	fs := srv.loadFs(t, map[string]string{"basePath": "/users/alice/"})
+
+	file, err := fs.Create("/big.bin")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	// Unblocks the upload if the test fails before it completes
+	defer func() { _ = file.Close() }()
+
+	chunk := bytes.Repeat([]byte("0123456789"), 10*1024)
+	if _, err := file.Write(chunk); err != nil {
+		t.Fatalf("couldn't write: %v", err)
+	}
+
+	// The content reaches the server before the file is closed
+	select {
+	case <-srv.fs.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upload isn't streamed")
+	}
+
+	for range 24 {
+		if _, err := file.Write(chunk); err != nil {
+			t.Fatalf("couldn't write: %v", err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("couldn't close: %v", err)
+	}
+
+	if put := srv.methodRequests(http.MethodPut); len(put) != 1 || put[0].ContentLength != -1 ||
+		put[0].URL.Path != "/dav/users/alice/big.bin" {
+		t.Fatalf("expected a single chunked PUT, got %v", put)
+	}
+
+	// Downloads use ranged GETs
+	fstest.CheckRandomAccess(t, fs, "/big.bin", bytes.Repeat(chunk, 25))
+}
+
+func TestDirectories(t *testing.T) {
+	srv := newTestServer(t, basicAuth)
+	fs := srv.loadFs(t, map[string]string{"url": srv.URL + "/dav/"})
+
+	fstest.CheckDirectories(t, fs)
+
+	// The WebDAV status codes are mapped to the os errors
+	if err := fs.Mkdir("/dir", 0o755); err != nil {
+		t.Fatalf("couldn't create dir: %v", err)
+	}
+
+	if err := fs.Mkdir("/dir", 0o755); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected ErrExist, got %v", err)
+	}
+
+	if err := fs.Mkdir("/missing/dir", 0o755); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+
+	if err := fs.RemoveAll("/missing"); err != nil {
+		t.Fatalf("removing a missing dir shouldn't fail: %v", err)
+	}
+
+	// The dates come from the PROPFIND responses
+	if info, err := fs.Stat("/dir"); err != nil || info.ModTime().IsZero() {
+		t.Fatalf("unexpected stat of /dir: %v, %v", info, err)
+	}
+
+	fstest.CheckListing(t, fs, "/", "dir")
+}
+
+func TestBearerToken(t *testing.T) {
+	srv := newTestServer(t, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer token"
+	})
+
+	fstest.WriteFile(t, srv.loadFs(t, map[string]string{"token": "token"}), "/file.txt", []byte("hello"))
+
+	fs := srv.loadFs(t, map[string]string{"token": "wrong"})
+	if _, err := fs.Stat("/file.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected ErrPermission, got %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/other.txt", []byte("hello"), 0o644); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected ErrPermission, got %v", err)
+	}
+}
+
+func TestLoadFsErrors(t *testing.T) {
+	if _, err := LoadFs(&confpar.Access{Params: map[string]string{}}); !errors.Is(err, ErrNoURL) {
+		t.Fatalf("expected ErrNoURL, got %v", err)
+	}
+
+	params := map[string]string{"url": "https://example.com", "timeout": "soon"}
+	if _, err := LoadFs(&confpar.Access{Params: params}); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
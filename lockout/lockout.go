@@ -0,0 +1,300 @@
+// Package lockout tracks failed logins to temporarily ban remote IPs and users
+package lockout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Kinds of bans
+const (
+	KindIP   = "ip"
+	KindUser = "user"
+)
+
+// Default settings
+const (
+	DefaultMaxAttempts = 5
+	DefaultWindow      = 10 * time.Minute
+	DefaultBanDuration = 30 * time.Minute
+	DefaultMaxDelay    = 10 * time.Second
+)
+
+// ErrUnknownBan is returned when removing a ban that doesn't exist
+var ErrUnknownBan = errors.New("unknown ban")
+
+// Ban describes a remote IP or a user that can't log in
+type Ban struct {
+	Kind  string    `json:"kind"`  // Kind of ban: ip or user
+	Value string    `json:"value"` // Banned IP or user
+	Until time.Time `json:"until"` // End of the ban
+}
+
+// Tracker counts the failed logins and bans the IPs and users reaching the limit
+type Tracker struct {
+	sync.Mutex
+	failures  map[string][]time.Time // Failed logins, by key
+	bans      map[string]*Ban        // Current bans, by key
+	stateFile string                 // File where the bans are persisted
+	now       func() time.Time
+	lastPrune time.Time // Last time the old failures and bans were dropped
+}
+
+// NewTracker creates a tracker, loading the bans from a state file if one is given
+func NewTracker(stateFile string) (*Tracker, error) {
+	tracker := &Tracker{
+		failures:  make(map[string][]time.Time),
+		bans:      make(map[string]*Ban),
+		stateFile: stateFile,
+		now:       time.Now,
+	}
+
+	if stateFile == "" {
+		return tracker, nil
+	}
+
+	content, err := os.ReadFile(stateFile) //nolint:gosec // The state file comes from the config
+	if errors.Is(err, os.ErrNotExist) {
+		return tracker, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read bans state file: %w", err)
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(content, &bans); err != nil {
+		return nil, fmt.Errorf("could not parse bans state file: %w", err)
+	}
+
+	now := tracker.now()
+
+	for _, ban := range bans {
+		if ban.Until.After(now) {
+			tracker.bans[key(ban.Kind, ban.Value)] = ban
+		}
+	}
+
+	return tracker, nil
+}
+
+func key(kind, value string) string {
+	return kind + ":" + value
+}
+
+// Banned returns the ban of an IP or user, or nil if there is none
+func (t *Tracker) Banned(kind, value string) *Ban {
+	t.Lock()
+	defer t.Unlock()
+
+	ban := t.bans[key(kind, value)]
+	if ban == nil {
+		return nil
+	}
+
+	if !ban.Until.After(t.now()) {
+		delete(t.bans, key(kind, value))
+
+		return nil
+	}
+
+	return ban
+}
+
+// Fail records a failed login from an IP for a user. It returns the delay to apply before answering, based on
+// the number of recent failures of the IP, and the bans this failure triggered.
+func (t *Tracker) Fail(conf *confpar.BruteForce, ip, user string) (time.Duration, []*Ban, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	maxAttempts, window, banDuration := conf.MaxAttempts, conf.Window.Duration, conf.BanDuration.Duration
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	if banDuration <= 0 {
+		banDuration = DefaultBanDuration
+	}
+
+	now := t.now()
+	t.prune(now, window)
+
+	var (
+		newBans    []*Ban
+		ipFailures int
+	)
+
+	for _, kv := range [][2]string{{KindIP, ip}, {KindUser, user}} {
+		kind, value := kv[0], kv[1]
+		if value == "" {
+			continue
+		}
+
+		k := key(kind, value)
+		failures := append(recent(t.failures[k], now.Add(-window)), now)
+
+		if kind == KindIP {
+			ipFailures = len(failures)
+		}
+
+		if len(failures) < maxAttempts {
+			t.failures[k] = failures
+
+			continue
+		}
+
+		delete(t.failures, k)
+
+		ban := &Ban{Kind: kind, Value: value, Until: now.Add(banDuration)}
+		t.bans[k] = ban
+		newBans = append(newBans, ban)
+	}
+
+	var err error
+	if len(newBans) > 0 {
+		err = t.save()
+	}
+
+	return delay(conf, ipFailures), newBans, err
+}
+
+// prune drops the failures outside of the window and the expired bans, at most once per window. Otherwise, the
+// failures of IPs and users that never come back would be kept forever. The lock must be held.
+func (t *Tracker) prune(now time.Time, window time.Duration) {
+	if now.Sub(t.lastPrune) < window {
+		return
+	}
+
+	t.lastPrune = now
+
+	for k, failures := range t.failures {
+		if failures = recent(failures, now.Add(-window)); len(failures) > 0 {
+			t.failures[k] = failures
+		} else {
+			delete(t.failures, k)
+		}
+	}
+
+	for k, ban := range t.bans {
+		if !ban.Until.After(now) {
+			delete(t.bans, k)
+		}
+	}
+}
+
+// recent drops the failures that happened before a given time
+func recent(failures []time.Time, since time.Time) []time.Time {
+	kept := failures[:0]
+
+	for _, failure := range failures {
+		if failure.After(since) {
+			kept = append(kept, failure)
+		}
+	}
+
+	return kept
+}
+
+// delay returns the delay to apply after some failures, doubled after each of them
+func delay(conf *confpar.BruteForce, failures int) time.Duration {
+	if conf.Delay.Duration <= 0 || failures <= 0 {
+		return 0
+	}
+
+	maxDelay := conf.MaxDelay.Duration
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	d := conf.Delay.Duration
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, maxDelay)
+}
+
+// Reset forgets about the failed logins of an IP or user
+func (t *Tracker) Reset(kind, value string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.failures, key(kind, value))
+}
+
+// Bans returns the current bans, by kind and value
+func (t *Tracker) Bans() []*Ban {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	list := make([]*Ban, 0, len(t.bans))
+
+	for _, ban := range t.bans {
+		if ban.Until.After(now) {
+			list = append(list, ban)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+
+		return list[i].Value < list[j].Value
+	})
+
+	return list
+}
+
+// Unban removes the ban of an IP or user
+func (t *Tracker) Unban(kind, value string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	k := key(kind, value)
+	if t.bans[k] == nil {
+		return fmt.Errorf("%w: %s %s", ErrUnknownBan, kind, value)
+	}
+
+	delete(t.bans, k)
+	delete(t.failures, k)
+
+	return t.save()
+}
+
+// save persists the bans to the state file, if any. The lock must be held.
+func (t *Tracker) save() error {
+	if t.stateFile == "" {
+		return nil
+	}
+
+	bans := make([]*Ban, 0, len(t.bans))
+	for _, ban := range t.bans {
+		bans = append(bans, ban)
+	}
+
+	content, err := json.Marshal(bans)
+	if err != nil {
+		return err
+	}
+
+	// Writing to a temporary file first avoids ending up with a truncated state
+	tmpFile := t.stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil { //nolint:gomnd
+		return err
+	}
+
+	return os.Rename(tmpFile, t.stateFile)
+}
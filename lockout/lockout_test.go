@@ -0,0 +1,152 @@
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestBan(t *testing.T) {
+	tracker, err := NewTracker("")
+	if err != nil {
+		t.Fatalf("couldn't create tracker: %v", err)
+	}
+
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	conf := &confpar.BruteForce{
+		MaxAttempts: 3,
+		Window:      confpar.Duration{Duration: time.Minute},
+		BanDuration: confpar.Duration{Duration: time.Hour},
+	}
+
+	// Failures outside of the window are forgotten
+	for range 2 {
+		if _, bans, errFail := tracker.Fail(conf, "1.2.3.4", "bob"); errFail != nil || len(bans) != 0 {
+			t.Fatalf("unexpected bans: %v, %v", bans, errFail)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	for range 2 {
+		if _, bans, errFail := tracker.Fail(conf, "1.2.3.4", "alice"); errFail != nil || len(bans) != 0 {
+			t.Fatalf("unexpected bans: %v, %v", bans, errFail)
+		}
+	}
+
+	_, bans, err := tracker.Fail(conf, "1.2.3.4", "bob")
+	if err != nil || len(bans) != 1 || bans[0].Kind != KindIP || bans[0].Value != "1.2.3.4" {
+		t.Fatalf("the IP should have been banned: %v, %v", bans, err)
+	}
+
+	if tracker.Banned(KindIP, "1.2.3.4") == nil {
+		t.Fatal("the IP should be banned")
+	}
+
+	if tracker.Banned(KindUser, "bob") != nil {
+		t.Fatal("the user shouldn't be banned")
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	if tracker.Banned(KindIP, "1.2.3.4") != nil {
+		t.Fatal("the ban should have expired")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tracker, err := NewTracker("")
+	if err != nil {
+		t.Fatalf("couldn't create tracker: %v", err)
+	}
+
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	conf := &confpar.BruteForce{MaxAttempts: 3, Window: confpar.Duration{Duration: time.Minute}}
+
+	// A distributed attack with a different IP and user for each attempt
+	for i := range 100 {
+		if _, _, errFail := tracker.Fail(conf, fmt.Sprintf("10.0.%d.%d", i/256, i%256), fmt.Sprint("user", i)); errFail != nil {
+			t.Fatalf("couldn't record failure: %v", errFail)
+		}
+	}
+
+	if len(tracker.failures) != 200 {
+		t.Fatalf("unexpected number of tracked failures: %d", len(tracker.failures))
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if _, _, errFail := tracker.Fail(conf, "1.2.3.4", "bob"); errFail != nil {
+		t.Fatalf("couldn't record failure: %v", errFail)
+	}
+
+	if len(tracker.failures) != 2 {
+		t.Fatalf("the old failures should have been dropped: %d left", len(tracker.failures))
+	}
+}
+
+func TestDelay(t *testing.T) {
+	conf := &confpar.BruteForce{
+		Delay:    confpar.Duration{Duration: time.Second},
+		MaxDelay: confpar.Duration{Duration: 5 * time.Second},
+	}
+
+	for failures, expected := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := delay(conf, failures); d != expected {
+			t.Fatalf("delay after %d failures: %v instead of %v", failures, d, expected)
+		}
+	}
+
+	if d := delay(&confpar.BruteForce{}, 3); d != 0 {
+		t.Fatalf("no delay expected: %v", d)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "bans.json")
+
+	tracker, err := NewTracker(stateFile)
+	if err != nil {
+		t.Fatalf("couldn't create tracker: %v", err)
+	}
+
+	conf := &confpar.BruteForce{MaxAttempts: 1}
+
+	if _, _, err := tracker.Fail(conf, "1.2.3.4", "bob"); err != nil {
+		t.Fatalf("couldn't save bans: %v", err)
+	}
+
+	tracker, err = NewTracker(stateFile)
+	if err != nil {
+		t.Fatalf("couldn't load tracker: %v", err)
+	}
+
+	if bans := tracker.Bans(); len(bans) != 2 || bans[0].Kind != KindIP || bans[1].Kind != KindUser {
+		t.Fatalf("bans weren't restored: %v", bans)
+	}
+
+	if err := tracker.Unban(KindUser, "bob"); err != nil {
+		t.Fatalf("couldn't unban: %v", err)
+	}
+
+	if err := tracker.Unban(KindUser, "bob"); !errors.Is(err, ErrUnknownBan) {
+		t.Fatalf("expected ErrUnknownBan, got: %v", err)
+	}
+
+	tracker, err = NewTracker(stateFile)
+	if err != nil {
+		t.Fatalf("couldn't load tracker: %v", err)
+	}
+
+	if bans := tracker.Bans(); len(bans) != 1 || bans[0].Value != "1.2.3.4" {
+		t.Fatalf("unexpected bans: %v", bans)
+	}
+}
@@ -2,7 +2,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -21,6 +23,11 @@ var (
 	driver    *server.Server
 )
 
+var (
+	errUnknownCommand = errors.New("unknown command")
+	errMissingUser    = errors.New("a user name is required")
+)
+
 func main() {
 	// Arguments vars
 	var confFile string
@@ -29,11 +36,21 @@ func main() {
 	// Parsing arguments
 	flag.StringVar(&confFile, "conf", "", "Configuration file")
 	flag.BoolVar(&onlyConf, "conf-only", false, "Only create the conf")
+	flag.Usage = usage
 	flag.Parse()
 
 	// Setting up the logger
 	logger := slog.Default()
 
+	if flag.NArg() > 0 {
+		if err := runCommand(confFile, flag.Args(), logger); err != nil {
+			logger.Error("Command failed", "command", flag.Arg(0), "err", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	logger.Info("FTP server", "version", BuildVersion, "date", BuildDate, "commit", Commit)
 
 	autoCreate := onlyConf
@@ -90,6 +107,22 @@ func main() {
 		return
 	}
 
+	if conf.Content.Metrics != nil {
+		go func() {
+			if err := driver.ServeMetrics(); err != nil {
+				logger.Error("Problem serving metrics", "err", err)
+			}
+		}()
+	}
+
+	if conf.Content.Admin != nil {
+		go func() {
+			if err := driver.ServeAdmin(); err != nil {
+				logger.Error("Problem serving admin API", "err", err)
+			}
+		}()
+	}
+
 	// Instantiating the server by passing our driver implementation
 	ftpServer = ftpserver.NewFtpServer(driver)
 
@@ -116,6 +149,58 @@ func main() {
 	}
 }
 
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [options] [command]\n\nCommands:\n", os.Args[0])
+	_, _ = fmt.Fprintln(out, "  totp-enroll [-issuer name] <user>  Generate the TOTP secret of a user and print its otpauth URI")
+	_, _ = fmt.Fprintln(out, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+// runCommand runs a one-shot command instead of the server
+func runCommand(confFile string, args []string, logger *slog.Logger) error {
+	switch args[0] {
+	case "totp-enroll":
+		return enrollTOTP(confFile, args[1:], logger)
+	default:
+		flag.Usage()
+
+		return fmt.Errorf("%w: %s", errUnknownCommand, args[0])
+	}
+}
+
+// enrollTOTP generates the TOTP secret of a user and prints its otpauth URI
+func enrollTOTP(confFile string, args []string, logger *slog.Logger) error {
+	flags := flag.NewFlagSet("totp-enroll", flag.ExitOnError)
+	issuer := flags.String("issuer", "ftpserver", "Issuer shown in the authenticator app")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errMissingUser
+	}
+
+	if confFile == "" {
+		confFile = "ftpserver.json"
+	}
+
+	conf, err := config.NewConfig(confFile, logger)
+	if err != nil {
+		return err
+	}
+
+	uri, err := conf.EnrollTOTP(flags.Arg(0), *issuer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(uri) //nolint:forbidigo // Output of the command
+
+	return nil
+}
+
 func stop() {
 	driver.Stop()
 
@@ -0,0 +1,84 @@
+// Package metrics provides the server metrics, exposed in the Prometheus text format
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Transfer directions
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Login results
+const (
+	LoginOk     = "ok"
+	LoginFailed = "failed"
+)
+
+// Metrics holds all the server metrics
+type Metrics struct {
+	registry            *prometheus.Registry
+	ConnectedClients    prometheus.Gauge         // Connected clients
+	Logins              *prometheus.CounterVec   // Logins, by access and result
+	TransferredBytes    *prometheus.CounterVec   // Bytes transferred, by user, fs and direction
+	TransferDuration    *prometheus.HistogramVec // Duration of transfers, by fs and direction
+	FsErrors            *prometheus.CounterVec   // Backend errors, by fs and operation
+	WebhookAuthDuration prometheus.Histogram     // Duration of the accesses webhook calls
+}
+
+// NewMetrics creates the metrics and their registry
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		ConnectedClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ftpserver_connected_clients",
+			Help: "Number of connected clients",
+		}),
+		Logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ftpserver_logins_total",
+			Help: "Number of logins, by access and result",
+		}, []string{"access", "result"}),
+		TransferredBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ftpserver_transferred_bytes_total",
+			Help: "Number of bytes transferred, by user, backend and direction",
+		}, []string{"user", "fs", "direction"}),
+		TransferDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ftpserver_transfer_duration_seconds",
+			Help:    "Duration of the file transfers, by backend and direction",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10), //nolint:gomnd
+		}, []string{"fs", "direction"}),
+		FsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ftpserver_fs_errors_total",
+			Help: "Number of backend operations errors, by backend and operation",
+		}, []string{"fs", "op"}),
+		WebhookAuthDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ftpserver_webhook_auth_duration_seconds",
+			Help:    "Duration of the accesses webhook calls",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.ConnectedClients,
+		m.Logins,
+		m.TransferredBytes,
+		m.TransferDuration,
+		m.FsErrors,
+		m.WebhookAuthDuration,
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
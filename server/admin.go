@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fclairamb/ftpserver/lockout"
+)
+
+// ErrMissingAdminToken is returned when the admin API is enabled without any token
+var ErrMissingAdminToken = errors.New("admin API requires a token")
+
+// ServeAdmin exposes the admin API. It's a blocking call, similar to http.ListenAndServe.
+func (s *Server) ServeAdmin() error {
+	conf := s.config.Content.Admin
+	if conf == nil || conf.ListenAddress == "" {
+		return ErrNotEnabled
+	}
+
+	if conf.Token == "" {
+		return ErrMissingAdminToken
+	}
+
+	s.logger.Info("Serving admin API", "address", conf.ListenAddress)
+
+	srv := &http.Server{
+		Addr:              conf.ListenAddress,
+		Handler:           s.AdminHandler(),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+
+	return srv.ListenAndServe()
+}
+
+// AdminHandler returns the HTTP handler of the admin API:
+// - GET /api/sessions lists the sessions
+// - DELETE /api/sessions/{id} disconnects a session
+// - GET /api/bans lists the IPs and users banned after too many failed logins
+// - DELETE /api/bans/{kind}/{value} removes a ban
+// - POST /api/reload reloads the config
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.Sessions())
+	})
+
+	mux.HandleFunc("DELETE /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		clientID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid session id"})
+
+			return
+		}
+
+		if err := s.KickSession(uint32(clientID)); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrUnknownSession) {
+				status = http.StatusNotFound
+			}
+
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/bans", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.Bans())
+	})
+
+	mux.HandleFunc("DELETE /api/bans/{kind}/{value}", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Unban(r.PathValue("kind"), r.PathValue("value")); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, lockout.ErrUnknownBan) {
+				status = http.StatusNotFound
+			}
+
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/reload", func(w http.ResponseWriter, _ *http.Request) {
+		if err := s.ReloadConfig(); err != nil {
+			s.logger.Warn("Error reloading config", "err", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		s.logger.Info("Successfully reloaded config")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return s.adminAuth(mux)
+}
+
+// adminAuth checks the bearer token of the admin API requests
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := s.config.Content.Admin
+		expected := ""
+
+		if conf != nil && conf.Token != "" {
+			expected = "Bearer " + conf.Token
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
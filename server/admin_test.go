@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/server"
+)
+
+// closableClientContext records the Close calls
+type closableClientContext struct {
+	*fakeClientContext
+	closed bool
+}
+
+func (c *closableClientContext) Close() error {
+	c.closed = true
+
+	return nil
+}
+
+func adminRequest(t *testing.T, handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAdminAPI(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		Admin: &confpar.Admin{ListenAddress: "127.0.0.1:0", Token: "secret"},
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+	handler := srv.AdminHandler()
+
+	first := &closableClientContext{fakeClientContext: newClientContext(1, "10.0.0.1")}
+	second := &closableClientContext{fakeClientContext: newClientContext(2, "10.0.0.2")}
+
+	for _, cc := range []*closableClientContext{first, second} {
+		if _, err := srv.ClientConnected(cc); err != nil {
+			t.Fatalf("client %d should be accepted: %v", cc.id, err)
+		}
+	}
+
+	if _, err := srv.AuthUser(first, "a", "a"); err != nil {
+		t.Fatalf("auth failed: %v", err)
+	}
+
+	if rec := adminRequest(t, handler, http.MethodGet, "/api/sessions", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", rec.Code)
+	}
+
+	rec := adminRequest(t, handler, http.MethodGet, "/api/sessions", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var sessions []*server.SessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("couldn't parse sessions: %v", err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+
+	if sessions[0].ClientID != 1 || sessions[0].User != "a" || sessions[0].LoginTime == nil {
+		t.Fatalf("unexpected authenticated session: %+v", sessions[0])
+	}
+
+	if sessions[1].ClientID != 2 || sessions[1].User != "" || sessions[1].LoginTime != nil {
+		t.Fatalf("unexpected anonymous session: %+v", sessions[1])
+	}
+
+	if rec := adminRequest(t, handler, http.MethodDelete, "/api/sessions/1", "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 when kicking, got %d", rec.Code)
+	}
+
+	if !first.closed || second.closed {
+		t.Fatal("only the first client should have been closed")
+	}
+
+	if rec := adminRequest(t, handler, http.MethodDelete, "/api/sessions/42", "secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown session, got %d", rec.Code)
+	}
+
+	if rec := adminRequest(t, handler, http.MethodDelete, "/api/sessions/abc", "secret"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid session, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrIPDenied is returned when a remote IP isn't allowed
+var ErrIPDenied = errors.New("IP not allowed")
+
+// ipInRanges checks if an IP is part of some ranges
+func ipInRanges(addr netip.Addr, ranges []confpar.IPRange) bool {
+	for _, r := range ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIP returns an error if an IP is denied, or if some ranges are allowed and it isn't part of them
+func checkIP(ip string, allowed, denied []confpar.IPRange) error {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("%w: %s can't be parsed", ErrIPDenied, ip)
+	}
+
+	addr = addr.Unmap()
+
+	if ipInRanges(addr, denied) {
+		return fmt.Errorf("%w: %s is denied", ErrIPDenied, ip)
+	}
+
+	if len(allowed) > 0 && !ipInRanges(addr, allowed) {
+		return fmt.Errorf("%w: %s isn't allowed", ErrIPDenied, ip)
+	}
+
+	return nil
+}
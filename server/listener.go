@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// rejectTimeout limits the time spent sending the reply to a rejected connection
+const rejectTimeout = 5 * time.Second
+
+// listen creates the control connection listener, when the PROXY protocol or the clients limit require it
+func (s *Server) listen(conf *confpar.Content, tlsRequired serverlib.TLSRequirement) (net.Listener, error) {
+	lc := &net.ListenConfig{}
+
+	tcpListener, err := lc.Listen(context.Background(), "tcp", conf.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on main port: %w", err)
+	}
+
+	listener := tcpListener
+
+	if conf.ProxyProtocol != nil {
+		if listener, err = s.proxyProtocolListener(tcpListener, conf); err != nil {
+			_ = tcpListener.Close()
+
+			return nil, err
+		}
+	}
+
+	var tlsConfig *tls.Config
+
+	if tlsRequired == serverlib.ImplicitEncryption {
+		// The PROXY header comes before the TLS handshake
+		if tlsConfig, err = s.GetTLSConfig(); err != nil {
+			_ = tcpListener.Close()
+
+			return nil, fmt.Errorf("cannot get tls config: %w", err)
+		}
+	}
+
+	switch {
+	case conf.MaxClients > 0:
+		// The clients limit does the TLS wrapping, the accepted connections must stay *tls.Conn for the client
+		// certificates to be verified
+		listener = &clientsListener{Listener: listener, server: s, tlsConfig: tlsConfig}
+	case tlsConfig != nil:
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	return listener, nil
+}
+
+// clientsListener rejects the connections over max_clients with a 421 reply. ftpserverlib replies to the errors of
+// ClientConnected with a 500, which clients don't take as a temporary failure.
+type clientsListener struct {
+	net.Listener
+	server    *Server
+	tlsConfig *tls.Config // Implicit TLS config, if any
+	mu        sync.Mutex
+	count     int // Connections accepted and not closed yet
+}
+
+// Accept returns the next connection within the clients limit
+func (l *clientsListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.reserve() {
+			return l.wrap(&clientConn{Conn: conn, release: l.release}), nil
+		}
+
+		// Reading the remote address or replying might wait for the client, which shouldn't block the other ones
+		go l.reject(conn)
+	}
+}
+
+// reserve counts a new connection if the limit allows it. The limit is read on each connection to follow the
+// config reloads.
+func (l *clientsListener) reserve() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if maxClients := l.server.config.Content.MaxClients; maxClients > 0 && l.count >= maxClients {
+		return false
+	}
+
+	l.count++
+
+	return true
+}
+
+// wrap applies the implicit TLS on a connection
+func (l *clientsListener) wrap(conn net.Conn) net.Conn {
+	if l.tlsConfig == nil {
+		return conn
+	}
+
+	return tls.Server(conn, l.tlsConfig)
+}
+
+func (l *clientsListener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count--
+}
+
+func (l *clientsListener) reject(conn net.Conn) {
+	l.server.logger.Warn(
+		"Too many clients, rejecting connection",
+		"remoteAddr", conn.RemoteAddr(),
+		"maxClients", l.server.config.Content.MaxClients,
+	)
+
+	// With implicit TLS, the reply is sent after the handshake
+	conn = l.wrap(conn)
+
+	_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+	_, _ = conn.Write([]byte(fmt.Sprintf("%d Too many clients, try again later\r\n", serverlib.StatusServiceNotAvailable)))
+	_ = conn.Close()
+}
+
+// clientConn releases its slot of the clients limit once closed
+type clientConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *clientConn) Close() error {
+	c.once.Do(c.release)
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/auth/ldap"
+	"github.com/fclairamb/ftpserver/auth/webhook"
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/lockout"
+)
+
+// ErrBanned is returned when a banned IP or user tries to log in
+var ErrBanned = errors.New("too many failed logins")
+
+// checkBan returns an error if an IP or user is banned
+func (s *Server) checkBan(kind, value string) error {
+	if s.config.Content.BruteForce == nil || value == "" {
+		return nil
+	}
+
+	if ban := s.lockout.Banned(kind, value); ban != nil {
+		return fmt.Errorf("%w: %s %s banned until %s", ErrBanned, kind, value, ban.Until.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// isCredentialsError checks if a login failed because of the credentials. The failures of the authentication
+// sources, like an unreachable LDAP server, aren't the fault of the user and aren't counted.
+func isCredentialsError(err error) bool {
+	return errors.Is(err, config.ErrUnknownUser) ||
+		errors.Is(err, webhook.ErrInvalidCredentials) ||
+		errors.Is(err, ldap.ErrInvalidCredentials)
+}
+
+// loginFailed records a failed login, and waits before the failure is answered
+func (s *Server) loginFailed(cc serverlib.ClientContext, user string) {
+	conf := s.config.Content.BruteForce
+	if conf == nil {
+		return
+	}
+
+	delay, bans, err := s.lockout.Fail(conf, remoteIP(cc.RemoteAddr()), user)
+	if err != nil {
+		s.logger.Error("Could not save the bans", "err", err)
+	}
+
+	for _, ban := range bans {
+		s.logger.Warn(
+			"Banning after too many failed logins",
+			"kind", ban.Kind,
+			"value", ban.Value,
+			"until", ban.Until,
+			"clientId", cc.ID(),
+		)
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// loginSucceeded forgets about the previous failed logins of a user
+func (s *Server) loginSucceeded(user string) {
+	if s.config.Content.BruteForce != nil {
+		s.lockout.Reset(lockout.KindUser, user)
+	}
+}
+
+// Bans returns the current bans
+func (s *Server) Bans() []*lockout.Ban {
+	return s.lockout.Bans()
+}
+
+// Unban removes the ban of an IP or user
+func (s *Server) Unban(kind, value string) error {
+	return s.lockout.Unban(kind, value)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+const httpReadHeaderTimeout = 10 * time.Second
+
+// ServeMetrics exposes the metrics in the Prometheus text format on the /metrics path.
+// It's a blocking call, similar to http.ListenAndServe.
+func (s *Server) ServeMetrics() error {
+	conf := s.config.Content.Metrics
+	if conf == nil || conf.ListenAddress == "" {
+		return ErrNotEnabled
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.Handler())
+
+	s.logger.Info("Serving metrics", "address", conf.ListenAddress)
+
+	srv := &http.Server{
+		Addr:              conf.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrNoTrustedSources is returned when the PROXY protocol is enabled without any trusted source
+var ErrNoTrustedSources = errors.New("proxy_protocol requires some trusted_sources")
+
+// proxyProtocolPolicy requires the PROXY header from the trusted sources and doesn't look for it on the other
+// connections. As the FTP server speaks first, waiting for an optional header would delay every direct client.
+func proxyProtocolPolicy(conf *confpar.ProxyProtocol) (proxyproto.PolicyFunc, error) {
+	if len(conf.TrustedSources) == 0 {
+		return nil, ErrNoTrustedSources
+	}
+
+	trusted := conf.TrustedSources
+
+	return func(upstream net.Addr) (proxyproto.Policy, error) {
+		addr, err := netip.ParseAddr(remoteIP(upstream))
+		if err != nil {
+			return proxyproto.SKIP, nil //nolint:nilerr // Not an IP connection, it can't be a trusted proxy
+		}
+
+		addr = addr.Unmap()
+
+		if ipInRanges(addr, trusted) {
+			return proxyproto.REQUIRE, nil
+		}
+
+		return proxyproto.SKIP, nil
+	}, nil
+}
+
+// proxyProtocolListener wraps the control connection listener to parse the PROXY protocol headers
+func (s *Server) proxyProtocolListener(listener net.Listener, conf *confpar.Content) (net.Listener, error) {
+	policy, err := proxyProtocolPolicy(conf.ProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("PROXY protocol enabled", "trustedSources", conf.ProxyProtocol.TrustedSources)
+
+	return &proxyproto.Listener{
+		Listener: listener,
+		Policy:   policy,
+	}, nil
+}
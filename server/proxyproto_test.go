@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// acceptWithHeader connects to a listener, optionally sending a PROXY header, and returns the accepted connection
+func acceptWithHeader(t *testing.T, listener net.Listener, header *proxyproto.Header) net.Conn {
+	t.Helper()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("couldn't connect: %v", err)
+	}
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	if header != nil {
+		if _, err := header.WriteTo(client); err != nil {
+			t.Fatalf("couldn't write PROXY header: %v", err)
+		}
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("couldn't accept connection: %v", err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestProxyProtocol(t *testing.T) {
+	for _, version := range []byte{1, 2} {
+		srv := newTestServer(t, &confpar.Content{
+			ListenAddress: "127.0.0.1:0",
+			ProxyProtocol: &confpar.ProxyProtocol{TrustedSources: ipRanges(t, "127.0.0.0/8")},
+		})
+
+		settings, err := srv.GetSettings()
+		if err != nil {
+			t.Fatalf("couldn't get settings: %v", err)
+		}
+
+		if settings.Listener == nil {
+			t.Fatal("a listener should have been created")
+		}
+
+		header := &proxyproto.Header{
+			Version:           version,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.TCPv4,
+			SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234},
+			DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 21},
+		}
+
+		conn := acceptWithHeader(t, settings.Listener, header)
+		if addr := conn.RemoteAddr().String(); addr != "203.0.113.7:51234" {
+			t.Fatalf("v%d: unexpected remote address: %s", version, addr)
+		}
+
+		_ = settings.Listener.Close()
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		ListenAddress: "127.0.0.1:0",
+		ProxyProtocol: &confpar.ProxyProtocol{TrustedSources: ipRanges(t, "10.0.0.1")},
+	})
+
+	settings, err := srv.GetSettings()
+	if err != nil {
+		t.Fatalf("couldn't get settings: %v", err)
+	}
+
+	defer func() { _ = settings.Listener.Close() }()
+
+	// Connections from other sources are used as they are, without waiting for any header
+	conn := acceptWithHeader(t, settings.Listener, nil)
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("unexpected remote address: %s", conn.RemoteAddr())
+	}
+}
@@ -2,27 +2,37 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"os"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/spf13/afero"
+	"golang.org/x/time/rate"
 
 	serverlib "github.com/fclairamb/ftpserverlib"
 
+	"github.com/fclairamb/ftpserver/auth/ldap"
+	"github.com/fclairamb/ftpserver/auth/sqlstore"
+	"github.com/fclairamb/ftpserver/auth/webhook"
 	"github.com/fclairamb/ftpserver/config"
 	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
 	"github.com/fclairamb/ftpserver/fs"
+	"github.com/fclairamb/ftpserver/fs/acl"
+	"github.com/fclairamb/ftpserver/fs/activity"
+	"github.com/fclairamb/ftpserver/fs/fsevents"
 	"github.com/fclairamb/ftpserver/fs/fslog"
+	"github.com/fclairamb/ftpserver/fs/fsmetrics"
+	"github.com/fclairamb/ftpserver/fs/quota"
+	"github.com/fclairamb/ftpserver/fs/throttle"
+	"github.com/fclairamb/ftpserver/fs/utils"
+	"github.com/fclairamb/ftpserver/lockout"
+	"github.com/fclairamb/ftpserver/metrics"
 )
 
 // Server structure
@@ -32,10 +42,22 @@ type Server struct { // nolint: maligned
 	nbClients       uint32
 	nbClientsSync   sync.Mutex
 	zeroClientEvent chan error
-	tlsOnce         sync.Once
-	tlsConfig       *tls.Config
-	tlsError        error
+	tlsSync         sync.Mutex
+	tlsConfig       *tls.Config  // TLS config, once loaded
+	tlsCert         *certificate // Hot-reloadable server certificate
 	accesses        *fsCache
+	sessions        map[uint32]*session // Authenticated sessions, by client ID
+	sessionsPerIP   map[string]int      // Number of authenticated sessions per remote IP
+	sessionsPerUser map[string]int      // Number of authenticated sessions per access user
+	limiters        *throttle.Limiters  // Bandwidth limiters, shared between sessions
+	usages          *quota.Usages       // Storage usages, shared between sessions
+	events          *events.Dispatcher  // Events hooks dispatcher
+	metrics         *metrics.Metrics    // Server metrics
+	lockout         *lockout.Tracker    // Failed logins tracker
+	webhookSync     sync.Mutex
+	webhook         *webhook.Client // Accesses webhook client, once created
+	sqlSync         sync.Mutex
+	sqlStore        *sqlstore.Store // SQL authentication store, once opened
 }
 
 type fsCache struct {
@@ -60,10 +82,28 @@ var ErrNotEnabled = errors.New("not enabled")
 
 // NewServer creates a server instance
 func NewServer(config *config.Config, logger *slog.Logger) (*Server, error) {
+	stateFile := ""
+	if config.Content.BruteForce != nil {
+		stateFile = config.Content.BruteForce.StateFile
+	}
+
+	tracker, err := lockout.NewTracker(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Server{
-		config:   config,
-		logger:   logger,
-		accesses: newFsCache(),
+		config:          config,
+		logger:          logger,
+		accesses:        newFsCache(),
+		sessions:        make(map[uint32]*session),
+		sessionsPerIP:   make(map[string]int),
+		sessionsPerUser: make(map[string]int),
+		limiters:        throttle.NewLimiters(),
+		usages:          quota.NewUsages(),
+		events:          events.NewDispatcher(logger.With("component", "events")),
+		metrics:         metrics.NewMetrics(),
+		lockout:         tracker,
 	}, nil
 }
 
@@ -90,7 +130,19 @@ func (s *Server) GetSettings() (*serverlib.Settings, error) {
 		tlsRequired = serverlib.ClearOrEncrypted
 	}
 
+	var listener net.Listener
+
+	if conf.ProxyProtocol != nil || conf.MaxClients > 0 {
+		var err error
+
+		listener, err = s.listen(conf, tlsRequired)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &serverlib.Settings{
+		Listener:                 listener,
 		ListenAddr:               conf.ListenAddress,
 		PublicHost:               conf.PublicHost,
 		PassiveTransferPortRange: portRange,
@@ -103,8 +155,14 @@ func (s *Server) GetSettings() (*serverlib.Settings, error) {
 		DefaultTransferType: serverlib.TransferTypeBinary,
 	}, nil
 }
+
+// ReloadConfig reloads the config and the TLS certificate
 func (s *Server) ReloadConfig() error {
-	return s.config.Load()
+	if err := s.config.Load(); err != nil {
+		return err
+	}
+
+	return s.reloadTLSConfig()
 }
 
 // ClientConnected is called to send the very first welcome message
@@ -112,6 +170,8 @@ func (s *Server) ClientConnected(cc serverlib.ClientContext) (string, error) {
 	s.nbClientsSync.Lock()
 	defer s.nbClientsSync.Unlock()
 	s.nbClients++
+	s.metrics.ConnectedClients.Set(float64(s.nbClients))
+	s.addSession(cc)
 	s.logger.Info(
 		"Client connected",
 		"clientId", cc.ID(),
@@ -119,6 +179,39 @@ func (s *Server) ClientConnected(cc serverlib.ClientContext) (string, error) {
 		"nbClients", s.nbClients,
 	)
 
+	if maxClients := s.config.Content.MaxClients; maxClients > 0 && s.nbClients > uint32(maxClients) {
+		s.logger.Warn(
+			"Too many clients, rejecting connection",
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+			"maxClients", maxClients,
+		)
+
+		return "Too many clients, try again later", ErrTooManyClients
+	}
+
+	if err := s.checkBan(lockout.KindIP, remoteIP(cc.RemoteAddr())); err != nil {
+		s.logger.Warn(
+			"Banned client, rejecting connection",
+			"err", err,
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+		)
+
+		return "Too many failed logins, try again later", err
+	}
+
+	if err := checkIP(remoteIP(cc.RemoteAddr()), s.config.Content.AllowedIPs, s.config.Content.DeniedIPs); err != nil {
+		s.logger.Warn(
+			"IP not allowed, rejecting connection",
+			"err", err,
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+		)
+
+		return "Connection not allowed", err
+	}
+
 	if s.config.Content.Logging.FtpExchanges {
 		cc.SetDebug(true)
 	}
@@ -132,6 +225,14 @@ func (s *Server) ClientDisconnected(cc serverlib.ClientContext) {
 	defer s.nbClientsSync.Unlock()
 
 	s.nbClients--
+	s.metrics.ConnectedClients.Set(float64(s.nbClients))
+
+	if sess := s.sessions[cc.ID()]; sess != nil && sess.user != "" {
+		s.fireEvent(cc, sess.user, &events.Event{Type: events.Logout})
+	}
+
+	s.removeSession(cc.ID())
+	s.config.DropPendingTOTP(cc.ID())
 
 	s.logger.Info(
 		"Client disconnected",
@@ -194,57 +295,110 @@ func (s *Server) loadFs(access *confpar.Access) (afero.Fs, error) {
 	return newFs, err
 }
 
-func (s *Server) getAccessFromWebhook(user, pass string) (*confpar.Access, error) {
-	// Convert payload to JSON
-	jsonData, err := json.Marshal(map[string]string{
-		"user": user,
-		"pass": pass,
-	})
+func (s *Server) getAccessFromWebhook(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	conf := s.config.Content.AccessesWebhook
+
+	client, err := s.getWebhookClient()
 	if err != nil {
 		return nil, err
 	}
 
-	// Timeout is implemented with context termination
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Content.AccessesWebhook.Timeout.Duration)
-	defer cancel()
+	access, err := client.GetAccess(&webhook.Request{
+		User:     user,
+		Pass:     pass,
+		RemoteIP: remoteIP(cc.RemoteAddr()),
+		ClientID: cc.ID(),
+		TLS:      cc.HasTLSForControl(),
+	})
+	// Only an unavailable webhook is replaced, its refusals and its invalid answers are final
+	if err == nil || !conf.FallbackToAccesses || !webhook.Unavailable(err) {
+		return access, err
+	}
 
-	// Create a new HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", s.config.Content.AccessesWebhook.URL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
+	s.logger.Warn("Accesses webhook failed, falling back to the accesses", "err", err, "userName", user, "clientId", cc.ID())
+
+	return s.config.GetClientAccess(loginClient(cc), user, pass)
+}
+
+// loginClient identifies the connection of a login, for the two-step TOTP logins
+func loginClient(cc serverlib.ClientContext) *config.LoginClient {
+	client := &config.LoginClient{ID: cc.ID()}
+	if addr := cc.RemoteAddr(); addr != nil {
+		client.RemoteAddr = addr.String()
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	for key, value := range s.config.Content.AccessesWebhook.Headers {
-		req.Header.Set(key, value)
+	return client
+}
+
+// getWebhookClient returns the accesses webhook client, re-created when the configuration was reloaded
+func (s *Server) getWebhookClient() (*webhook.Client, error) {
+	s.webhookSync.Lock()
+	defer s.webhookSync.Unlock()
+
+	conf := s.config.Content.AccessesWebhook
+	if s.webhook != nil && s.webhook.Config() == conf {
+		return s.webhook, nil
 	}
 
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client, err := webhook.NewClient(conf)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	s.webhook = client
+
+	return client, nil
+}
+
+func (s *Server) getAccessFromLDAP(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	access, err := s.config.GetClientAccess(loginClient(cc), user, pass)
+	if !errors.Is(err, config.ErrUnknownUser) {
+		return access, err
+	}
+
+	return ldap.GetAccess(s.config.Content.LDAP, user, pass)
+}
+
+func (s *Server) getAccessFromSQL(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	access, err := s.config.GetClientAccess(loginClient(cc), user, pass)
+	if !errors.Is(err, config.ErrUnknownUser) {
+		return access, err
 	}
 
-	// Return the response
-	body, err := io.ReadAll(resp.Body)
+	store, err := s.getSQLStore()
 	if err != nil {
 		return nil, err
 	}
 
-	access := new(confpar.Access)
-	err = json.Unmarshal(body, &access)
+	return store.GetAccess(user, pass)
+}
+
+// getSQLStore returns the SQL store, re-opened when the configuration was reloaded
+func (s *Server) getSQLStore() (*sqlstore.Store, error) {
+	s.sqlSync.Lock()
+	defer s.sqlSync.Unlock()
+
+	conf := s.config.Content.SQL
+	if s.sqlStore != nil && s.sqlStore.Config() == conf {
+		return s.sqlStore, nil
+	}
+
+	if s.sqlStore != nil {
+		if err := s.sqlStore.Close(); err != nil {
+			s.logger.Warn("Could not close SQL store", "err", err)
+		}
+
+		s.sqlStore = nil
+	}
+
+	store, err := sqlstore.NewStore(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	return access, nil
+	s.sqlStore = store
+
+	return store, nil
 }
 
 // AuthUser authenticates the user and selects an handling driver
@@ -254,52 +408,244 @@ func (s *Server) AuthUser(cc serverlib.ClientContext, user, pass string) (server
 		errAccess error
 	)
 
-	if s.config.Content.AccessesWebhook == nil {
-		// Get the access from the configuration
-		access, errAccess = s.config.GetAccess(user, pass)
-	} else {
+	errAccess = s.checkBan(lockout.KindIP, remoteIP(cc.RemoteAddr()))
+	if errAccess == nil {
+		errAccess = s.checkBan(lockout.KindUser, user)
+	}
+
+	if errAccess != nil {
+		s.metrics.Logins.WithLabelValues("", metrics.LoginFailed).Inc()
+
+		return nil, errAccess
+	}
+
+	switch {
+	case s.config.Content.AccessesWebhook != nil:
 		// Get the access from the webhook, not the configuration
-		access, errAccess = s.getAccessFromWebhook(user, pass)
+		start := time.Now()
+		access, errAccess = s.getAccessFromWebhook(cc, user, pass)
+		s.metrics.WebhookAuthDuration.Observe(time.Since(start).Seconds())
+	case s.config.Content.LDAP != nil:
+		// Get the access from the configuration, then from the LDAP server
+		access, errAccess = s.getAccessFromLDAP(cc, user, pass)
+	case s.config.Content.SQL != nil:
+		// Get the access from the configuration, then from the database
+		access, errAccess = s.getAccessFromSQL(cc, user, pass)
+	default:
+		// Get the access from the configuration
+		access, errAccess = s.config.GetClientAccess(loginClient(cc), user, pass)
+	}
+	if errors.Is(errAccess, config.ErrTOTPRequired) {
+		// The password was right, the first step of a two-step login isn't a failed login
+		return nil, errAccess
 	}
+
 	if errAccess != nil {
+		s.metrics.Logins.WithLabelValues("", metrics.LoginFailed).Inc()
+
+		if isCredentialsError(errAccess) {
+			s.loginFailed(cc, user)
+		} else {
+			s.logger.Error("Could not authenticate", "err", errAccess, "userName", user, "clientId", cc.ID())
+		}
+
 		return nil, errAccess
 	}
 
+	s.loginSucceeded(user)
+
+	// The authentication source might have a canonical name for the user
+	return s.login(cc, access.User, access)
+}
+
+// login opens the session of an authenticated access
+func (s *Server) login(cc serverlib.ClientContext, user string, access *confpar.Access) (serverlib.ClientDriver, error) {
+	resolved, err := s.resolveAccess(cc, access)
+	if err != nil {
+		s.logger.Error("Could not resolve access", "err", err, "userName", user, "clientId", cc.ID())
+		s.metrics.Logins.WithLabelValues(access.User, metrics.LoginFailed).Inc()
+
+		return nil, err
+	}
+
+	access = resolved
+
+	if err := checkIP(remoteIP(cc.RemoteAddr()), access.AllowedIPs, access.DeniedIPs); err != nil {
+		s.logger.Warn(
+			"IP not allowed for this access",
+			"err", err,
+			"userName", user,
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+		)
+		s.metrics.Logins.WithLabelValues(access.User, metrics.LoginFailed).Inc()
+
+		return nil, err
+	}
+
+	sess, err := s.openSession(cc, access)
+	if err != nil {
+		s.logger.Warn(
+			"Session rejected",
+			"err", err,
+			"userName", user,
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+		)
+		s.metrics.Logins.WithLabelValues(access.User, metrics.LoginFailed).Inc()
+
+		return nil, err
+	}
+
+	driver, err := s.loadClientDriver(cc, user, access, sess)
+	if err != nil {
+		s.releaseSession(cc.ID())
+		s.metrics.Logins.WithLabelValues(access.User, metrics.LoginFailed).Inc()
+
+		return nil, err
+	}
+
+	s.metrics.Logins.WithLabelValues(access.User, metrics.LoginOk).Inc()
+	s.fireEvent(cc, access.User, &events.Event{Type: events.Login})
+
+	return driver, nil
+}
+
+// resolveAccess applies the template of an access and renders its params
+func (s *Server) resolveAccess(cc serverlib.ClientContext, access *confpar.Access) (*confpar.Access, error) {
+	return s.config.ResolveAccess(access, map[string]string{
+		"User":     access.User,
+		"RemoteIP": remoteIP(cc.RemoteAddr()),
+	})
+}
+
+// fireEvent completes an event with the session details and runs its hooks
+func (s *Server) fireEvent(cc serverlib.ClientContext, user string, event *events.Event) {
+	if len(s.config.Content.Events) == 0 {
+		return
+	}
+
+	event.User = user
+	event.ClientID = cc.ID()
+	event.RemoteAddr = cc.RemoteAddr().String()
+
+	s.events.Fire(s.config.Content.Events, event)
+}
+
+// loadClientDriver creates the driver of an authenticated client
+func (s *Server) loadClientDriver(
+	cc serverlib.ClientContext,
+	user string,
+	access *confpar.Access,
+	sess *session,
+) (*ClientDriver, error) {
 	accFs, errFs := s.loadFs(access)
 
 	if errFs != nil {
 		return nil, errFs
 	}
 
+	if s.config.Content.Metrics != nil {
+		accFs, errFs = fsmetrics.LoadFS(accFs, s.metrics, access.User, access.Fs)
+		if errFs != nil {
+			return nil, errFs
+		}
+	}
+
+	logger := s.logger.With(
+		"userName", user,
+		"fs", access.Fs,
+		"clientId", cc.ID(),
+		"remoteAddr", cc.RemoteAddr(),
+	)
+
+	driver := &ClientDriver{}
+
+	if access.Quota != nil {
+		usage, err := s.usages.Get(access.User, accFs, access.Quota.StateFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if driver.quota, err = quota.LoadFS(accFs, usage, access.Quota); err != nil {
+			return nil, err
+		}
+
+		accFs = driver.quota
+	}
+
+	accFs, errFs = acl.LoadFS(accFs, access.ACL, logger)
+	if errFs != nil {
+		return nil, errFs
+	}
+
 	if s.config.Content.Logging.FtpExchanges || access.Logging.FtpExchanges {
 		cc.SetDebug(true)
 	}
 
 	if s.config.Content.Logging.FileAccesses || access.Logging.FileAccesses {
-		var err error
+		accFs, errFs = fslog.LoadFS(accFs, logger)
+		if errFs != nil {
+			return nil, errFs
+		}
+	}
 
-		logger := s.logger.With(
-			"userName", user,
-			"fs", access.Fs,
-			"clientId", cc.ID(),
-			"remoteAddr", cc.RemoteAddr(),
-		)
+	accFs, errFs = s.throttleFs(sess.ctx, accFs, access)
+	if errFs != nil {
+		return nil, errFs
+	}
 
-		accFs, err = fslog.LoadFS(accFs, logger)
+	accFs, errFs = activity.LoadFS(accFs, sess.activity)
+	if errFs != nil {
+		return nil, errFs
+	}
 
-		if err != nil {
-			return nil, err
+	if len(s.config.Content.Events) > 0 {
+		accFs, errFs = fsevents.LoadFS(accFs, func(event *events.Event) {
+			s.fireEvent(cc, access.User, event)
+		})
+		if errFs != nil {
+			return nil, errFs
 		}
 	}
 
-	return &ClientDriver{
-		Fs: accFs,
-	}, nil
+	driver.Fs = accFs
+
+	return driver, nil
+}
+
+// throttleFs applies the global and per-access bandwidth limits, until the end of the session
+func (s *Server) throttleFs(ctx context.Context, accFs afero.Fs, access *confpar.Access) (afero.Fs, error) {
+	conf := s.config.Content
+
+	return throttle.LoadFS(
+		ctx,
+		accFs,
+		[]*rate.Limiter{
+			s.limiters.Get("download", conf.MaxDownloadRate),
+			s.limiters.Get("download:"+access.User, access.MaxDownloadRate),
+		},
+		[]*rate.Limiter{
+			s.limiters.Get("upload", conf.MaxUploadRate),
+			s.limiters.Get("upload:"+access.User, access.MaxUploadRate),
+		},
+	)
 }
 
 // The ClientDriver is the internal structure used for handling the client. At this stage it's limited to the afero.Fs
 type ClientDriver struct {
 	afero.Fs
+	quota *quota.Fs // Storage quota, if any
+}
+
+// GetAvailableSpace returns the space left by the access quota. It implements ftpserverlib's
+// ClientDriverExtensionAvailableSpace interface (the "AVBL" command).
+func (d *ClientDriver) GetAvailableSpace(dirName string) (int64, error) {
+	if d.quota == nil {
+		return 0, ErrNotEnabled
+	}
+
+	return d.quota.GetAvailableSpace(dirName)
 }
 
 // Symlink creates a symbolic link. It implements ftpserverlib's
@@ -308,11 +654,7 @@ type ClientDriver struct {
 // Backends that can't create symlinks return an *os.LinkError wrapping
 // afero.ErrNoSymlink. See #980.
 func (d *ClientDriver) Symlink(oldname, newname string) error {
-	if linker, ok := d.Fs.(afero.Linker); ok {
-		return linker.SymlinkIfPossible(oldname, newname)
-	}
-
-	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	return utils.Symlink(d.Fs, oldname, newname)
 }
 
 func (s *Server) loadTLSConfig() (*tls.Config, error) {
@@ -321,37 +663,74 @@ func (s *Server) loadTLSConfig() (*tls.Config, error) {
 		return nil, ErrNotEnabled
 	}
 
-	serverCert := tlsConf.ServerCert
+	if s.tlsCert == nil {
+		cert, err := newCertificate(tlsConf.ServerCert.Cert, tlsConf.ServerCert.Key, s.logger)
+		if err != nil {
+			return nil, err
+		}
+
+		s.tlsCert = cert
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.tlsCert.GetCertificate,
+	}
 
-	certBytes, errReadFileCert := os.ReadFile(serverCert.Cert)
-	if errReadFileCert != nil {
-		return nil, fmt.Errorf("could not load cert file: %s: %w", serverCert.Cert, errReadFileCert)
+	if err := setClientAuth(tlsConfig, tlsConf); err != nil {
+		return nil, err
 	}
 
-	keyBytes, errReadFileKey := os.ReadFile(serverCert.Key)
-	if errReadFileKey != nil {
-		return nil, fmt.Errorf("could not load key file: %s: %w", serverCert.Cert, errReadFileCert)
+	return tlsConfig, nil
+}
+
+// reloadTLSConfig reloads the TLS certificate and client CA from the current config
+func (s *Server) reloadTLSConfig() error {
+	s.tlsSync.Lock()
+	defer s.tlsSync.Unlock()
+
+	tlsConf := s.config.Content.TLS
+	if tlsConf == nil || tlsConf.ServerCert == nil {
+		s.tlsConfig, s.tlsCert = nil, nil
+
+		return nil
 	}
 
-	keypair, errKeyPair := tls.X509KeyPair(certBytes, keyBytes)
-	if errKeyPair != nil {
-		return nil, fmt.Errorf("could not parse key pairs: %w", errKeyPair)
+	if s.tlsCert == nil {
+		// Not loaded yet, it will be on the next TLS connection
+		return nil
 	}
 
-	return &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{keypair},
-	}, nil
+	if err := s.tlsCert.setFiles(tlsConf.ServerCert.Cert, tlsConf.ServerCert.Key); err != nil {
+		return fmt.Errorf("could not reload the TLS certificate, keeping the current one: %w", err)
+	}
+
+	tlsConfig, err := s.loadTLSConfig()
+	if err != nil {
+		return fmt.Errorf("could not reload the TLS config, keeping the current one: %w", err)
+	}
+
+	s.tlsConfig = tlsConfig
+
+	return nil
 }
 
 // GetTLSConfig returns a TLS Certificate to use
 // The certificate could frequently change if we use something like "let's encrypt"
 func (s *Server) GetTLSConfig() (*tls.Config, error) {
 	// The function is called every single time a control or transfer connection requires a TLS connection. As such
-	// it's important to cache it.
-	s.tlsOnce.Do(func() {
-		s.tlsConfig, s.tlsError = s.loadTLSConfig()
-	})
+	// it's important to cache it. The certificate itself is reloaded whenever its files change.
+	s.tlsSync.Lock()
+	defer s.tlsSync.Unlock()
+
+	if s.tlsConfig == nil {
+		tlsConfig, err := s.loadTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		s.tlsConfig = tlsConfig
+	}
 
-	return s.tlsConfig, s.tlsError
+	return s.tlsConfig, nil
 }
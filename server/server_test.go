@@ -1,13 +1,29 @@
 package server_test
 
 import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // RFC 6238 default
+	"encoding/base32"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	serverlib "github.com/fclairamb/ftpserverlib"
 	"github.com/spf13/afero"
 
+	"github.com/fclairamb/ftpserver/auth/webhook"
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
 	"github.com/fclairamb/ftpserver/server"
 )
 
@@ -59,3 +75,571 @@ func TestClientDriverSymlinkUnsupported(t *testing.T) {
 		t.Fatalf("expected afero.ErrNoSymlink, got: %v", err)
 	}
 }
+
+// TestClientDriverSymlinkWrapped checks that the file system wrappers of a session keep the symlink support of the
+// backend
+func TestClientDriverSymlinkWrapped(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "target.txt"), []byte("hello"), 0o600); err != nil {
+		t.Fatalf("couldn't write target file: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		Metrics:         &confpar.Metrics{},
+		MaxDownloadRate: 1 << 20,
+		Logging:         confpar.Logging{FileAccesses: true},
+		Events:          []*confpar.EventHook{{On: []string{"upload"}, Command: "true"}},
+		Accesses: []*confpar.Access{
+			{
+				User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": dir},
+				Quota: &confpar.Quota{MaxFiles: 10},
+				ACL:   []*confpar.ACLRule{{Path: "/private/**", Deny: []string{"download"}}},
+			},
+		},
+	})
+
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err := srv.AuthUser(cc, "a", "a")
+	if err != nil {
+		t.Fatalf("couldn't authenticate: %v", err)
+	}
+
+	linker, ok := driver.(serverlib.ClientDriverExtensionSymlink)
+	if !ok {
+		t.Fatal("the driver should support symlinks")
+	}
+
+	if err := linker.Symlink("/target.txt", "/link.txt"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(dir, "link.txt")); err != nil || filepath.Base(target) != "target.txt" {
+		t.Fatalf("unexpected link %q: %v", target, err)
+	}
+
+	// Links can't give access to denied paths
+	if err := linker.Symlink("/private", "/public"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected a permission error, got: %v", err)
+	}
+}
+
+// TestThrottleSessionEnd checks that the end of a session interrupts its throttled transfers
+func TestThrottleSessionEnd(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}, MaxUploadRate: 1000},
+		},
+	})
+
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err := srv.AuthUser(cc, "a", "a")
+	if err != nil {
+		t.Fatalf("couldn't authenticate: %v", err)
+	}
+
+	file, err := driver.(afero.Fs).Create("/big.bin")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, errWrite := file.Write(make([]byte, 100000))
+		done <- errWrite
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	srv.ClientDisconnected(cc)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("the write should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upload wasn't interrupted")
+	}
+}
+
+// fakeClientContext only implements the ClientContext methods used by the driver
+type fakeClientContext struct {
+	serverlib.ClientContext
+	id   uint32
+	addr net.Addr
+}
+
+func (c *fakeClientContext) ID() uint32 { return c.id }
+
+func (c *fakeClientContext) RemoteAddr() net.Addr { return c.addr }
+
+func (c *fakeClientContext) SetDebug(bool) {}
+
+func (c *fakeClientContext) HasTLSForControl() bool { return false }
+
+func newClientContext(id uint32, ip string) *fakeClientContext {
+	return &fakeClientContext{id: id, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000 + int(id)}}
+}
+
+func newTestServer(t *testing.T, content *confpar.Content) *server.Server {
+	t.Helper()
+
+	conf, err := config.FromContent(content, "test.json", slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't create config: %v", err)
+	}
+
+	srv, err := server.NewServer(conf, slog.Default())
+	if err != nil {
+		t.Fatalf("couldn't create server: %v", err)
+	}
+
+	return srv
+}
+
+func TestMaxClients(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{MaxClients: 1})
+
+	first := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(first); err != nil {
+		t.Fatalf("first client should be accepted: %v", err)
+	}
+
+	second := newClientContext(2, "10.0.0.2")
+	if _, err := srv.ClientConnected(second); !errors.Is(err, server.ErrTooManyClients) {
+		t.Fatalf("expected ErrTooManyClients, got: %v", err)
+	}
+
+	srv.ClientDisconnected(second)
+	srv.ClientDisconnected(first)
+
+	if _, err := srv.ClientConnected(second); err != nil {
+		t.Fatalf("client should be accepted once the others left: %v", err)
+	}
+}
+
+func TestMaxClientsReply(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{ListenAddress: "127.0.0.1:0", MaxClients: 1})
+	ftpServer := serverlib.NewFtpServer(srv)
+
+	if err := ftpServer.Listen(); err != nil {
+		t.Fatalf("couldn't listen: %v", err)
+	}
+
+	go func() { _ = ftpServer.Serve() }()
+
+	t.Cleanup(func() { _ = ftpServer.Stop() })
+
+	readReply := func(conn net.Conn) int {
+		code, _, err := textproto.NewReader(bufio.NewReader(conn)).ReadResponse(0)
+		if err != nil {
+			t.Fatalf("couldn't read reply: %v", err)
+		}
+
+		return code
+	}
+
+	first, err := net.Dial("tcp", ftpServer.Addr())
+	if err != nil {
+		t.Fatalf("couldn't connect: %v", err)
+	}
+
+	defer func() { _ = first.Close() }()
+
+	if code := readReply(first); code != serverlib.StatusServiceReady {
+		t.Fatalf("unexpected reply to the first client: %d", code)
+	}
+
+	second, err := net.Dial("tcp", ftpServer.Addr())
+	if err != nil {
+		t.Fatalf("couldn't connect: %v", err)
+	}
+
+	defer func() { _ = second.Close() }()
+
+	if code := readReply(second); code != serverlib.StatusServiceNotAvailable {
+		t.Fatalf("expected a 421 reply, got %d", code)
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		MaxSessionsPerIP: 2,
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}, MaxSessions: 1},
+			{User: "b", Pass: "b", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	clients := []*fakeClientContext{
+		newClientContext(1, "10.0.0.1"),
+		newClientContext(2, "10.0.0.1"),
+		newClientContext(3, "10.0.0.1"),
+		newClientContext(4, "10.0.0.2"),
+	}
+
+	for _, cc := range clients {
+		if _, err := srv.ClientConnected(cc); err != nil {
+			t.Fatalf("client %d should be accepted: %v", cc.id, err)
+		}
+	}
+
+	if _, err := srv.AuthUser(clients[0], "a", "a"); err != nil {
+		t.Fatalf("first session of a should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(clients[3], "a", "a"); !errors.Is(err, server.ErrTooManySessions) {
+		t.Fatalf("expected per-user ErrTooManySessions, got: %v", err)
+	}
+
+	if _, err := srv.AuthUser(clients[1], "b", "b"); err != nil {
+		t.Fatalf("second session from the IP should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(clients[2], "b", "b"); !errors.Is(err, server.ErrTooManySessions) {
+		t.Fatalf("expected per-IP ErrTooManySessions, got: %v", err)
+	}
+
+	srv.ClientDisconnected(clients[0])
+
+	if _, err := srv.AuthUser(clients[3], "a", "a"); err != nil {
+		t.Fatalf("session of a should be accepted once the first one left: %v", err)
+	}
+}
+
+func TestBruteForce(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		BruteForce: &confpar.BruteForce{MaxAttempts: 2},
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	for id := uint32(1); id <= 2; id++ {
+		cc := newClientContext(id, "10.0.0.1")
+		if _, err := srv.ClientConnected(cc); err != nil {
+			t.Fatalf("client %d should be accepted: %v", id, err)
+		}
+
+		if _, err := srv.AuthUser(cc, "a", "wrong"); err == nil {
+			t.Fatal("wrong password should be refused")
+		}
+
+		srv.ClientDisconnected(cc)
+	}
+
+	cc := newClientContext(3, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); !errors.Is(err, server.ErrBanned) {
+		t.Fatalf("expected ErrBanned for the IP, got: %v", err)
+	}
+
+	srv.ClientDisconnected(cc)
+
+	other := newClientContext(4, "10.0.0.2")
+	if _, err := srv.ClientConnected(other); err != nil {
+		t.Fatalf("another IP should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(other, "a", "a"); !errors.Is(err, server.ErrBanned) {
+		t.Fatalf("expected ErrBanned for the user, got: %v", err)
+	}
+
+	if err := srv.Unban("user", "a"); err != nil {
+		t.Fatalf("couldn't unban the user: %v", err)
+	}
+
+	if _, err := srv.AuthUser(other, "a", "a"); err != nil {
+		t.Fatalf("the user should be accepted once unbanned: %v", err)
+	}
+
+	if bans := srv.Bans(); len(bans) != 1 || bans[0].Value != "10.0.0.1" {
+		t.Fatalf("only the IP should still be banned: %v", bans)
+	}
+}
+
+func TestBruteForceBackendOutage(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer hook.Close()
+
+	srv := newTestServer(t, &confpar.Content{
+		BruteForce:      &confpar.BruteForce{MaxAttempts: 1},
+		AccessesWebhook: &confpar.AccessesWebhook{URL: hook.URL},
+	})
+
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(cc, "a", "a"); !errors.Is(err, webhook.ErrUnexpectedStatus) {
+		t.Fatalf("expected ErrUnexpectedStatus, got: %v", err)
+	}
+
+	// The outage of the webhook isn't counted as a failed login
+	if bans := srv.Bans(); len(bans) != 0 {
+		t.Fatalf("nothing should be banned: %v", bans)
+	}
+}
+
+func TestBruteForceTOTP(t *testing.T) {
+	secret, err := config.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("couldn't generate secret: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		BruteForce: &confpar.BruteForce{MaxAttempts: 2, Delay: confpar.Duration{Duration: time.Minute}},
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", TOTPSecret: secret, Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	// The first step of two-step logins is neither counted nor delayed
+	for id := uint32(1); id <= 3; id++ {
+		cc := newClientContext(id, "10.0.0.1")
+		if _, err := srv.ClientConnected(cc); err != nil {
+			t.Fatalf("client %d should be accepted: %v", id, err)
+		}
+
+		start := time.Now()
+
+		if _, err := srv.AuthUser(cc, "a", "a"); !errors.Is(err, config.ErrTOTPRequired) {
+			t.Fatalf("expected ErrTOTPRequired, got: %v", err)
+		}
+
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("the first step shouldn't be delayed")
+		}
+
+		srv.ClientDisconnected(cc)
+	}
+
+	if bans := srv.Bans(); len(bans) != 0 {
+		t.Fatalf("nothing should be banned: %v", bans)
+	}
+}
+
+// totpNow returns the current TOTP code of a secret
+func totpNow(t *testing.T, secret string) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("couldn't decode secret: %v", err)
+	}
+
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, time.Now().Unix()/30)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTPTwoStepOtherClient(t *testing.T) {
+	secret, err := config.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("couldn't generate secret: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", TOTPSecret: secret, Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	user := newClientContext(1, "10.0.0.1")
+	attacker := newClientContext(2, "10.6.6.6")
+
+	for _, cc := range []*fakeClientContext{user, attacker} {
+		if _, err := srv.ClientConnected(cc); err != nil {
+			t.Fatalf("client %d should be accepted: %v", cc.id, err)
+		}
+	}
+
+	if _, err := srv.AuthUser(user, "a", "a"); !errors.Is(err, config.ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got: %v", err)
+	}
+
+	// The code alone only completes the login of the client that sent the password
+	if _, err := srv.AuthUser(attacker, "a", totpNow(t, secret)); !errors.Is(err, config.ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got: %v", err)
+	}
+
+	if _, err := srv.AuthUser(user, "a", totpNow(t, secret)); err != nil {
+		t.Fatalf("the user should complete the login: %v", err)
+	}
+}
+
+// ipRanges parses some IP ranges, like the config loading does
+func ipRanges(t *testing.T, values ...string) []confpar.IPRange {
+	t.Helper()
+
+	ranges := make([]confpar.IPRange, 0, len(values))
+
+	for _, value := range values {
+		r, err := confpar.ParseIPRange(value)
+		if err != nil {
+			t.Fatalf("couldn't parse IP range: %v", err)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+func TestIPFilters(t *testing.T) {
+	srv := newTestServer(t, &confpar.Content{
+		AllowedIPs: ipRanges(t, "10.0.0.0/8", "2001:db8::/32"),
+		DeniedIPs:  ipRanges(t, "10.6.6.6"),
+		Accesses: []*confpar.Access{
+			{
+				User: "partner", Pass: "partner", Fs: "os", Params: map[string]string{"basePath": t.TempDir()},
+				AllowedIPs: ipRanges(t, "10.1.0.0/16"),
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"2001:db8::1", true},
+		{"10.6.6.6", false},
+		{"192.168.1.1", false},
+	} {
+		cc := newClientContext(1, tc.ip)
+		if _, err := srv.ClientConnected(cc); (err == nil) != tc.allowed {
+			t.Fatalf("unexpected result for %s: %v", tc.ip, err)
+		} else if err != nil && !errors.Is(err, server.ErrIPDenied) {
+			t.Fatalf("expected ErrIPDenied for %s, got: %v", tc.ip, err)
+		}
+
+		srv.ClientDisconnected(cc)
+	}
+
+	outside := newClientContext(2, "10.2.0.1")
+	if _, err := srv.ClientConnected(outside); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(outside, "partner", "partner"); !errors.Is(err, server.ErrIPDenied) {
+		t.Fatalf("expected ErrIPDenied for the access, got: %v", err)
+	}
+
+	inside := newClientContext(3, "10.1.0.1")
+	if _, err := srv.ClientConnected(inside); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(inside, "partner", "partner"); err != nil {
+		t.Fatalf("access should be allowed: %v", err)
+	}
+}
+
+func TestAccessTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "alice-10.0.0.1"), 0o750); err != nil {
+		t.Fatalf("couldn't create dir: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		AccessTemplates: map[string]*confpar.Access{
+			"home": {Fs: "os", Params: map[string]string{"basePath": dir + "/{{.User}}-{{.RemoteIP}}"}},
+		},
+		Accesses: []*confpar.Access{
+			{User: "alice", Pass: "alice", Template: "home"},
+		},
+	})
+
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err := srv.AuthUser(cc, "alice", "alice")
+	if err != nil {
+		t.Fatalf("alice should be authenticated: %v", err)
+	}
+
+	file, err := driver.Create("/hello.txt")
+	if err != nil {
+		t.Fatalf("couldn't create file: %v", err)
+	}
+
+	_ = file.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "alice-10.0.0.1", "hello.txt")); err != nil {
+		t.Fatalf("the file should be in the rendered base path: %v", err)
+	}
+}
+
+func TestWebhookFallback(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer hook.Close()
+
+	srv := newTestServer(t, &confpar.Content{
+		AccessesWebhook: &confpar.AccessesWebhook{
+			URL:                hook.URL,
+			Secret:             "s3cr3t",
+			VerifyResponse:     true,
+			FallbackToAccesses: true,
+		},
+		Accesses: []*confpar.Access{
+			{User: "a", Pass: "a", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	if _, err := srv.AuthUser(cc, "a", "a"); err != nil {
+		t.Fatalf("the accesses should be used when the webhook is down: %v", err)
+	}
+
+	// A refusal of the webhook isn't overridden by the accesses
+	status = http.StatusUnauthorized
+
+	if _, err := srv.AuthUser(cc, "a", "a"); !errors.Is(err, webhook.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	// Neither are the answers that can't be trusted
+	status = http.StatusOK
+
+	if _, err := srv.AuthUser(cc, "a", "a"); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got: %v", err)
+	}
+
+	status = http.StatusNotFound
+
+	if _, err := srv.AuthUser(cc, "a", "a"); !errors.Is(err, webhook.ErrUnexpectedStatus) {
+		t.Fatalf("expected ErrUnexpectedStatus, got: %v", err)
+	}
+
+	// An unreachable webhook is replaced
+	hook.Close()
+
+	if _, err := srv.AuthUser(cc, "a", "a"); err != nil {
+		t.Fatalf("the accesses should be used when the webhook is unreachable: %v", err)
+	}
+}
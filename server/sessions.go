@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/activity"
+)
+
+// ErrTooManyClients is returned when the max_clients limit is reached
+var ErrTooManyClients = errors.New("too many clients")
+
+// ErrTooManySessions is returned when a per-IP or per-access session limit is reached
+var ErrTooManySessions = errors.New("too many sessions")
+
+// ErrUnknownSession is returned when a session can't be found
+var ErrUnknownSession = errors.New("unknown session")
+
+// session describes a connected client
+type session struct {
+	cc          serverlib.ClientContext // Client context
+	ip          string                  // Remote IP
+	connectTime time.Time               // Connection time
+	user        string                  // Access user, empty until authenticated
+	loginTime   time.Time               // Authentication time
+	activity    *activity.Activity      // Transfers activity
+	clientCert  *x509.Certificate       // Verified TLS client certificate
+	ctx         context.Context         // Cancelled when the client disconnects
+	cancel      context.CancelFunc      // Interrupts the waits of the session
+}
+
+// SessionInfo describes a session for the admin API
+type SessionInfo struct {
+	ClientID        uint32             `json:"client_id"`            // Client ID
+	User            string             `json:"user,omitempty"`       // Access user
+	RemoteAddr      string             `json:"remote_addr"`          // Remote address
+	ConnectTime     time.Time          `json:"connect_time"`         // Connection time
+	LoginTime       *time.Time         `json:"login_time,omitempty"` // Authentication time
+	BytesDownloaded int64              `json:"bytes_downloaded"`     // Bytes downloaded
+	BytesUploaded   int64              `json:"bytes_uploaded"`       // Bytes uploaded
+	Transfer        *activity.Transfer `json:"transfer,omitempty"`   // Current transfer
+}
+
+// remoteIP returns the IP part of a remote address
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// addSession registers a connected client, nbClientsSync must be held
+func (s *Server) addSession(cc serverlib.ClientContext) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sess := &session{
+		cc:          cc,
+		ip:          remoteIP(cc.RemoteAddr()),
+		connectTime: time.Now(),
+		activity:    &activity.Activity{},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	s.sessions[cc.ID()] = sess
+
+	return sess
+}
+
+// removeSession forgets about a disconnected client, nbClientsSync must be held
+func (s *Server) removeSession(clientID uint32) {
+	s.closeSession(clientID)
+
+	if sess := s.sessions[clientID]; sess != nil {
+		sess.cancel()
+	}
+
+	delete(s.sessions, clientID)
+}
+
+// openSession authenticates a session after checking the client certificate and the per-IP and per-access limits
+func (s *Server) openSession(cc serverlib.ClientContext, access *confpar.Access) (*session, error) {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	sess := s.sessions[cc.ID()]
+	if sess == nil {
+		sess = s.addSession(cc)
+	}
+
+	// A client can authenticate again on the same control connection
+	s.closeSession(cc.ID())
+
+	if access.ClientCert != nil && !matchClientCert(sess.clientCert, access) {
+		return nil, ErrClientCertMismatch
+	}
+
+	if maxPerIP := s.config.Content.MaxSessionsPerIP; maxPerIP > 0 && s.sessionsPerIP[sess.ip] >= maxPerIP {
+		return nil, fmt.Errorf("%w: %d sessions from %s", ErrTooManySessions, maxPerIP, sess.ip)
+	}
+
+	if access.MaxSessions > 0 && s.sessionsPerUser[access.User] >= access.MaxSessions {
+		return nil, fmt.Errorf("%w: %d sessions for %s", ErrTooManySessions, access.MaxSessions, access.User)
+	}
+
+	sess.user = access.User
+	sess.loginTime = time.Now()
+	s.sessionsPerIP[sess.ip]++
+	s.sessionsPerUser[access.User]++
+
+	return sess, nil
+}
+
+// setSessionCert records the verified TLS client certificate of a client
+func (s *Server) setSessionCert(clientID uint32, cert *x509.Certificate) {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	if sess := s.sessions[clientID]; sess != nil {
+		sess.clientCert = cert
+	}
+}
+
+// closeSession unauthenticates a session, nbClientsSync must be held
+func (s *Server) closeSession(clientID uint32) {
+	sess := s.sessions[clientID]
+	if sess == nil || sess.user == "" {
+		return
+	}
+
+	if s.sessionsPerIP[sess.ip]--; s.sessionsPerIP[sess.ip] <= 0 {
+		delete(s.sessionsPerIP, sess.ip)
+	}
+
+	if s.sessionsPerUser[sess.user]--; s.sessionsPerUser[sess.user] <= 0 {
+		delete(s.sessionsPerUser, sess.user)
+	}
+
+	sess.user = ""
+	sess.loginTime = time.Time{}
+}
+
+// releaseSession is the locked version of closeSession
+func (s *Server) releaseSession(clientID uint32) {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	s.closeSession(clientID)
+}
+
+// Sessions returns the connected clients, by client ID
+func (s *Server) Sessions() []*SessionInfo {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	list := make([]*SessionInfo, 0, len(s.sessions))
+
+	for id, sess := range s.sessions {
+		info := &SessionInfo{
+			ClientID:    id,
+			User:        sess.user,
+			RemoteAddr:  sess.cc.RemoteAddr().String(),
+			ConnectTime: sess.connectTime,
+		}
+
+		if sess.user != "" {
+			loginTime := sess.loginTime
+			info.LoginTime = &loginTime
+		}
+
+		info.BytesDownloaded, info.BytesUploaded, info.Transfer = sess.activity.Stats()
+
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ClientID < list[j].ClientID
+	})
+
+	return list
+}
+
+// KickSession disconnects a client
+func (s *Server) KickSession(clientID uint32) error {
+	s.nbClientsSync.Lock()
+	sess := s.sessions[clientID]
+	s.nbClientsSync.Unlock()
+
+	if sess == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownSession, clientID)
+	}
+
+	s.logger.Info("Kicking client", "clientId", clientID, "userName", sess.user, "remoteAddr", sess.cc.RemoteAddr())
+
+	return sess.cc.Close()
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/lockout"
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+// ErrInvalidClientAuth is returned when the client_auth setting isn't supported
+var ErrInvalidClientAuth = errors.New("invalid client_auth, expected none, optional or required")
+
+// ErrNoClientCA is returned when client certificates are requested without any CA to verify them
+var ErrNoClientCA = errors.New("client_ca is required to verify client certificates")
+
+// ErrClientCertMismatch is returned when an access requires a client certificate that wasn't provided
+var ErrClientCertMismatch = errors.New("client certificate doesn't match the access")
+
+// certificate is a TLS key pair that is reloaded when its files change
+type certificate struct {
+	sync.Mutex
+	logger   *slog.Logger
+	certFile string           // Public certificate(s) file
+	keyFile  string           // Private key file
+	certTime time.Time        // Modification time of the certificate file at last load
+	keyTime  time.Time        // Modification time of the key file at last load
+	keypair  *tls.Certificate // Current key pair
+}
+
+func newCertificate(certFile, keyFile string, logger *slog.Logger) (*certificate, error) {
+	cert := &certificate{logger: logger}
+
+	if err := cert.setFiles(certFile, keyFile); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// setFiles (re)loads the key pair from the given files
+func (c *certificate) setFiles(certFile, keyFile string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.certFile, c.keyFile = certFile, keyFile
+
+	certTime, keyTime, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+
+	return c.load(certTime, keyTime)
+}
+
+// modTimes returns the modification times of the certificate and key files
+func (c *certificate) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("could not stat cert file: %s: %w", c.certFile, err)
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("could not stat key file: %s: %w", c.keyFile, err)
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// load reads and parses the key pair, the current one is kept if it fails
+func (c *certificate) load(certTime, keyTime time.Time) error {
+	// The files are only considered again once they change
+	c.certTime, c.keyTime = certTime, keyTime
+
+	certBytes, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return fmt.Errorf("could not load cert file: %s: %w", c.certFile, err)
+	}
+
+	keyBytes, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load key file: %s: %w", c.keyFile, err)
+	}
+
+	keypair, err := tls.X509KeyPair(certBytes, keyBytes)
+	if err != nil {
+		return fmt.Errorf("could not parse key pairs: %w", err)
+	}
+
+	c.keypair = &keypair
+
+	return nil
+}
+
+// GetCertificate returns the current key pair, after reloading it if its files changed
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	certTime, keyTime, err := c.modTimes()
+
+	switch {
+	case err != nil:
+		c.logger.Warn("Could not check the TLS certificate, keeping the current one", "err", err)
+	case !certTime.Equal(c.certTime) || !keyTime.Equal(c.keyTime):
+		if errLoad := c.load(certTime, keyTime); errLoad != nil {
+			c.logger.Warn("Could not reload the TLS certificate, keeping the current one", "err", errLoad)
+		} else {
+			c.logger.Info("Reloaded the TLS certificate", "cert", c.certFile)
+		}
+	}
+
+	return c.keypair, nil
+}
+
+// setClientAuth sets the client certificates verification of a TLS config
+func setClientAuth(tlsConfig *tls.Config, tlsConf *confpar.TLS) error {
+	switch tlsConf.ClientAuth {
+	case "", "none":
+		return nil
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidClientAuth, tlsConf.ClientAuth)
+	}
+
+	if tlsConf.ClientCA == "" {
+		return ErrNoClientCA
+	}
+
+	caBytes, err := os.ReadFile(tlsConf.ClientCA)
+	if err != nil {
+		return fmt.Errorf("could not load client CA file: %s: %w", tlsConf.ClientCA, err)
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(caBytes) {
+		return fmt.Errorf("%w: no certificate found in %s", ErrNoClientCA, tlsConf.ClientCA)
+	}
+
+	return nil
+}
+
+// matchClientCert checks if a verified client certificate matches the one expected by an access
+func matchClientCert(cert *x509.Certificate, access *confpar.Access) bool {
+	expected := access.ClientCert
+	if cert == nil || expected == nil {
+		return false
+	}
+
+	if expected.CommonName == "" && expected.SAN == "" {
+		return cert.Subject.CommonName == access.User
+	}
+
+	if expected.CommonName != "" && cert.Subject.CommonName != expected.CommonName {
+		return false
+	}
+
+	if expected.SAN != "" && !hasSAN(cert, expected.SAN) {
+		return false
+	}
+
+	return true
+}
+
+// hasSAN checks if a certificate contains a subject alternative name
+func hasSAN(cert *x509.Certificate, san string) bool {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, name := range names {
+		if name == san {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyConnection is called when a client sends the USER command on a TLS control connection. The verified
+// client certificate is kept for the authentication, and accesses with skip_password are logged in right away.
+func (s *Server) VerifyConnection(
+	cc serverlib.ClientContext,
+	user string,
+	tlsConn *tls.Conn,
+) (serverlib.ClientDriver, error) {
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, nil //nolint:nilnil // The password is required
+	}
+
+	cert := state.VerifiedChains[0][0]
+	s.setSessionCert(cc.ID(), cert)
+
+	// Only the accesses of the config file can skip the password: the webhook, LDAP and SQL sources need the
+	// password to find the access. The access is resolved again by login, from its unrendered version.
+	rawAccess, err := s.config.GetAccessByUser(user)
+	if err != nil {
+		return nil, nil //nolint:nilnil // The password is required
+	}
+
+	access, err := s.resolveAccess(cc, rawAccess)
+	// The certificate doesn't replace a TOTP code
+	if err != nil || access.ClientCert == nil || !access.ClientCert.SkipPassword || access.TOTPSecret != "" {
+		return nil, nil //nolint:nilnil // The password is required
+	}
+
+	if err := s.checkBan(lockout.KindIP, remoteIP(cc.RemoteAddr())); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBan(lockout.KindUser, user); err != nil {
+		return nil, err
+	}
+
+	if !matchClientCert(cert, access) {
+		s.logger.Warn(
+			"Client certificate mismatch",
+			"userName", user,
+			"commonName", cert.Subject.CommonName,
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+		)
+		s.metrics.Logins.WithLabelValues(access.User, metrics.LoginFailed).Inc()
+		s.loginFailed(cc, user)
+
+		return nil, ErrClientCertMismatch
+	}
+
+	s.loginSucceeded(user)
+
+	return s.login(cc, user, rawAccess)
+}
@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// writeKeyPair writes a self-signed certificate for the given common name
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldn't create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("couldn't marshal key: %v", err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), modTime)
+}
+
+func writeFile(t *testing.T, name string, content []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatalf("couldn't write %s: %v", name, err)
+	}
+
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatalf("couldn't change times of %s: %v", name, err)
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+
+	writeKeyPair(t, certFile, keyFile, "first", now.Add(-time.Hour))
+
+	srv := newTestServer(t, &confpar.Content{
+		TLS: &confpar.TLS{ServerCert: &confpar.ServerCert{Cert: certFile, Key: keyFile}},
+	})
+
+	tlsConfig, err := srv.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("couldn't get TLS config: %v", err)
+	}
+
+	commonName := func() string {
+		t.Helper()
+
+		cert, errCert := tlsConfig.GetCertificate(nil)
+		if errCert != nil {
+			t.Fatalf("couldn't get certificate: %v", errCert)
+		}
+
+		parsed, errParse := x509.ParseCertificate(cert.Certificate[0])
+		if errParse != nil {
+			t.Fatalf("couldn't parse certificate: %v", errParse)
+		}
+
+		return parsed.Subject.CommonName
+	}
+
+	if name := commonName(); name != "first" {
+		t.Fatalf("unexpected certificate: %s", name)
+	}
+
+	// Renewed certificate
+	writeKeyPair(t, certFile, keyFile, "second", now)
+
+	if name := commonName(); name != "second" {
+		t.Fatalf("certificate wasn't reloaded: %s", name)
+	}
+
+	// Broken certificate
+	writeFile(t, certFile, []byte("garbage"), now.Add(time.Minute))
+
+	if name := commonName(); name != "second" {
+		t.Fatalf("certificate should have been kept: %s", name)
+	}
+}
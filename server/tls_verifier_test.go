@@ -0,0 +1,288 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/server"
+)
+
+// testCA is a certificate authority issuing client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldn't create CA: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("couldn't parse CA: %v", err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, emails ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: commonName},
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("couldn't create certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsHandshake connects a TLS client to the server's TLS config and returns the server side
+func tlsHandshake(t *testing.T, srv *server.Server, clientCert *tls.Certificate) *tls.Conn {
+	t.Helper()
+
+	tlsConfig, err := srv.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("couldn't get TLS config: %v", err)
+	}
+
+	serverSide, clientSide := net.Pipe()
+	t.Cleanup(func() {
+		_ = serverSide.Close()
+		_ = clientSide.Close()
+	})
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true} //nolint:gosec // Test server certificate
+	if clientCert != nil {
+		clientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	client := tls.Client(clientSide, clientConfig)
+	errClient := make(chan error, 1)
+
+	go func() { errClient <- client.Handshake() }()
+
+	serverConn := tls.Server(serverSide, tlsConfig)
+	if err := serverConn.Handshake(); err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+
+	if err := <-errClient; err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+
+	return serverConn
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	writeKeyPair(t, certFile, keyFile, "server", time.Now())
+
+	ca := newTestCA(t)
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatalf("couldn't write CA: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		TLS: &confpar.TLS{
+			ServerCert: &confpar.ServerCert{Cert: certFile, Key: keyFile},
+			ClientCA:   caFile,
+			ClientAuth: "optional",
+		},
+		Accesses: []*confpar.Access{
+			{
+				User: "robot", Pass: "unused", Fs: "os", Params: map[string]string{"basePath": t.TempDir()},
+				ClientCert: &confpar.ClientCert{SkipPassword: true},
+			},
+			{
+				User: "partner", Pass: "partner", Fs: "os", Params: map[string]string{"basePath": t.TempDir()},
+				ClientCert: &confpar.ClientCert{SAN: "ops@partner.example"},
+			},
+		},
+	})
+
+	robotCert := ca.issue(t, "robot")
+	partnerCert := ca.issue(t, "someone", "ops@partner.example")
+
+	// Certificate alone
+	cc := newClientContext(1, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err := srv.VerifyConnection(cc, "robot", tlsHandshake(t, srv, &robotCert))
+	if err != nil || driver == nil {
+		t.Fatalf("robot should be authenticated by its certificate: %v, %v", driver, err)
+	}
+
+	// Wrong certificate
+	cc = newClientContext(2, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	if _, err := srv.VerifyConnection(cc, "robot", tlsHandshake(t, srv, &partnerCert)); !errors.Is(err, server.ErrClientCertMismatch) {
+		t.Fatalf("expected ErrClientCertMismatch, got: %v", err)
+	}
+
+	// Certificate and password
+	cc = newClientContext(3, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err = srv.VerifyConnection(cc, "partner", tlsHandshake(t, srv, &partnerCert))
+	if err != nil || driver != nil {
+		t.Fatalf("partner should still need a password: %v, %v", driver, err)
+	}
+
+	if _, err := srv.AuthUser(cc, "partner", "partner"); err != nil {
+		t.Fatalf("partner should be authenticated: %v", err)
+	}
+
+	// Password without certificate
+	cc = newClientContext(4, "10.0.0.1")
+	if _, err := srv.ClientConnected(cc); err != nil {
+		t.Fatalf("client should be accepted: %v", err)
+	}
+
+	driver, err = srv.VerifyConnection(cc, "partner", tlsHandshake(t, srv, nil))
+	if err != nil || driver != nil {
+		t.Fatalf("no certificate should require a password: %v, %v", driver, err)
+	}
+
+	if _, err := srv.AuthUser(cc, "partner", "partner"); !errors.Is(err, server.ErrClientCertMismatch) {
+		t.Fatalf("expected ErrClientCertMismatch, got: %v", err)
+	}
+}
+
+// TestClientCertificateMaxClients checks the client certificates on implicit TLS connections accepted within the
+// max_clients limit
+func TestClientCertificateMaxClients(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	writeKeyPair(t, certFile, keyFile, "server", time.Now())
+
+	ca := newTestCA(t)
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatalf("couldn't write CA: %v", err)
+	}
+
+	srv := newTestServer(t, &confpar.Content{
+		ListenAddress: "127.0.0.1:0",
+		MaxClients:    1,
+		TLSRequired:   "ImplicitEncryption",
+		TLS: &confpar.TLS{
+			ServerCert: &confpar.ServerCert{Cert: certFile, Key: keyFile},
+			ClientCA:   caFile,
+			ClientAuth: "optional",
+		},
+		Accesses: []*confpar.Access{
+			{
+				User: "robot", Pass: "unused", Fs: "os", Params: map[string]string{"basePath": t.TempDir()},
+				ClientCert: &confpar.ClientCert{SkipPassword: true},
+			},
+		},
+	})
+	ftpServer := serverlib.NewFtpServer(srv)
+
+	if err := ftpServer.Listen(); err != nil {
+		t.Fatalf("couldn't listen: %v", err)
+	}
+
+	go func() { _ = ftpServer.Serve() }()
+
+	t.Cleanup(func() { _ = ftpServer.Stop() })
+
+	robotCert := ca.issue(t, "robot")
+	clientConfig := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // Test server certificate
+		Certificates:       []tls.Certificate{robotCert},
+	}
+
+	first, err := tls.Dial("tcp", ftpServer.Addr(), clientConfig)
+	if err != nil {
+		t.Fatalf("couldn't connect: %v", err)
+	}
+
+	defer func() { _ = first.Close() }()
+
+	control := textproto.NewConn(first)
+
+	if _, _, err := control.ReadResponse(serverlib.StatusServiceReady); err != nil {
+		t.Fatalf("unexpected greeting: %v", err)
+	}
+
+	// The certificate alone logs the user in
+	if err := control.PrintfLine("USER robot"); err != nil {
+		t.Fatalf("couldn't send USER: %v", err)
+	}
+
+	if _, _, err := control.ReadResponse(serverlib.StatusUserLoggedIn); err != nil {
+		t.Fatalf("robot should be logged in by its certificate: %v", err)
+	}
+
+	second, err := tls.Dial("tcp", ftpServer.Addr(), clientConfig)
+	if err != nil {
+		t.Fatalf("couldn't connect: %v", err)
+	}
+
+	defer func() { _ = second.Close() }()
+
+	code, _, err := textproto.NewReader(bufio.NewReader(second)).ReadResponse(0)
+	if err != nil || code != serverlib.StatusServiceNotAvailable {
+		t.Fatalf("expected a 421 reply over TLS, got %d: %v", code, err)
+	}
+}